@@ -0,0 +1,8 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// abstractSockets is whether Unix socket addresses beginning with @ are bound in the abstract
+// socket namespace.
+const abstractSockets = true
@@ -0,0 +1,10 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package main
+
+// abstractSockets is false, since abstract Unix sockets are only available on Linux.
+const abstractSockets = false
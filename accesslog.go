@@ -0,0 +1,105 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"go.spiff.io/go-import-redirector/redirector"
+)
+
+// accessEntry is a line of the access log.
+type accessEntry struct {
+	Time       string  `json:"time"`
+	RequestID  string  `json:"request_id"`
+	Method     string  `json:"method"`
+	Host       string  `json:"host"`
+	Path       string  `json:"path"`
+	ImportRoot string  `json:"import_root,omitempty"`
+	VCSRoot    string  `json:"vcs_root,omitempty"`
+	Status     int     `json:"status"`
+	Duration   float64 `json:"duration_ms"`
+}
+
+// accessWriter is a ResponseWriter that records the response status and the redirect matched by a
+// request for the access log and metrics.
+type accessWriter struct {
+	http.ResponseWriter
+	status     int
+	importRoot string
+	vcsRoot    string
+	pattern    string
+}
+
+func (w *accessWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// RecordPattern implements redirector.PatternRecorder, passing the pattern on to the wrapped
+// ResponseWriter if it records patterns as well.
+func (w *accessWriter) RecordPattern(importPath string) {
+	w.pattern = importPath
+	if pr, ok := w.ResponseWriter.(redirector.PatternRecorder); ok {
+		pr.RecordPattern(importPath)
+	}
+}
+
+// RecordRoute implements redirector.RouteRecorder, passing the route on to the wrapped
+// ResponseWriter if it records routes as well.
+func (w *accessWriter) RecordRoute(importRoot, vcsRoot string) {
+	w.importRoot, w.vcsRoot = importRoot, vcsRoot
+	if rr, ok := w.ResponseWriter.(redirector.RouteRecorder); ok {
+		rr.RecordRoute(importRoot, vcsRoot)
+	}
+}
+
+// accessLog returns a handler that logs each request passed to next in the given format, either
+// text or json. Text lines are written through the standard logger, while JSON lines are written
+// on their own to its output.
+func accessLog(next http.Handler, format string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		aw := &accessWriter{ResponseWriter: w}
+		next.ServeHTTP(aw, req)
+		if aw.status == 0 {
+			aw.status = http.StatusOK
+		}
+
+		e := accessEntry{
+			Time:       start.UTC().Format(time.RFC3339Nano),
+			RequestID:  requestID(req),
+			Method:     req.Method,
+			Host:       req.Host,
+			Path:       req.URL.Path,
+			ImportRoot: aw.importRoot,
+			VCSRoot:    aw.vcsRoot,
+			Status:     aw.status,
+			Duration:   float64(time.Since(start)) / float64(time.Millisecond),
+		}
+		if format == "text" {
+			log.Printf("[%s] %s %s%s %d %.3fms import=%q vcs=%q",
+				e.RequestID, e.Method, e.Host, e.Path, e.Status, e.Duration, e.ImportRoot, e.VCSRoot)
+			return
+		}
+		b, err := json.Marshal(&e)
+		if err != nil {
+			log.Printf("error encoding access log entry: %v", err)
+			return
+		}
+		logWriter.Write(append(b, '\n'))
+	})
+}
@@ -0,0 +1,68 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+)
+
+// captureLog directs logWriter to a buffer until the returned function is called.
+func captureLog() (*bytes.Buffer, func()) {
+	var buf bytes.Buffer
+	old := logWriter
+	logWriter = &buf
+	return &buf, func() { logWriter = old }
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	buf, restore := captureLog()
+	defer restore()
+	h := accessLog(newTestRouter(t, "rsc.io/*", "https://github.com/rsc/*"), "json")
+	serve(h, "rsc.io/pdf/sub?go-get=1")
+	serve(h, "example.com/none")
+
+	dec := json.NewDecoder(buf)
+	want := []accessEntry{
+		{RequestID: "-", Method: "GET", Host: "rsc.io", Path: "/pdf/sub", ImportRoot: "rsc.io/pdf",
+			VCSRoot: "https://github.com/rsc/pdf", Status: 200},
+		{RequestID: "-", Method: "GET", Host: "example.com", Path: "/none", Status: 404},
+	}
+	for _, w := range want {
+		var e accessEntry
+		if err := dec.Decode(&e); err != nil {
+			t.Fatalf("error decoding access log entry: %v", err)
+		}
+		if _, err := time.Parse(time.RFC3339Nano, e.Time); err != nil {
+			t.Errorf("time %q is not an RFC 3339 time: %v", e.Time, err)
+		}
+		if e.Duration < 0 {
+			t.Errorf("duration_ms = %v, want a non-negative duration", e.Duration)
+		}
+		e.Time, e.Duration = "", 0
+		if e != w {
+			t.Errorf("access log entry = %+v, want %+v", e, w)
+		}
+	}
+	var extra accessEntry
+	if err := dec.Decode(&extra); err != io.EOF {
+		t.Errorf("access log has more than %d entries: %+v, %v", len(want), extra, err)
+	}
+
+	// The fields are written with the names in their tags.
+	buf.Reset()
+	serve(h, "rsc.io/pdf")
+	var fields map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &fields); err != nil {
+		t.Fatalf("error decoding access log entry %q: %v", buf.String(), err)
+	}
+	for _, name := range []string{"time", "request_id", "method", "host", "path", "import_root", "vcs_root", "status", "duration_ms"} {
+		if _, ok := fields[name]; !ok {
+			t.Errorf("access log entry %q has no %s field", buf.String(), name)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"go.spiff.io/go-import-redirector/redirector"
+	"gopkg.in/yaml.v3"
+)
+
+// configEntry is a redirect read from a config file.
+type configEntry struct {
+	Import  string `yaml:"import"`
+	Repo    string `yaml:"repo"`
+	VCS     string `yaml:"vcs"`
+	Docs    string `yaml:"docs"`
+	NoDocs  bool   `yaml:"no-docs"`
+	Message string `yaml:"message"`
+	Depth   int    `yaml:"depth"`
+	Source  struct {
+		Dir  string `yaml:"dir"`
+		File string `yaml:"file"`
+	} `yaml:"source"`
+	Routes     []configRoute `yaml:"routes"`
+	Deprecated struct {
+		Date      time.Time `yaml:"date"`
+		Sunset    time.Time `yaml:"sunset"`
+		Successor string    `yaml:"successor"`
+	} `yaml:"deprecated"`
+}
+
+// configRoute is a sub-route of a configEntry, serving a path under its import path from another
+// repo.
+type configRoute struct {
+	Path string `yaml:"path"`
+	Repo string `yaml:"repo"`
+	VCS  string `yaml:"vcs"`
+}
+
+// loadConfig reads redirects from the YAML config file at path. The file holds a list of entries,
+// each with an import path and repo URL, as would be given on the command line, and optionally the
+// VCS to use when the repo URL has no VCS prefix, the documentation base URL, the wildcard depth,
+// and go-source URL templates:
+//
+//	# redirects.yaml
+//	- import: rsc.io/*
+//	  repo: https://github.com/rsc/*
+//	- import: 9fans.net/go
+//	  repo: https://github.com/9fans/go
+//	  vcs: git
+//	  docs: https://godoc.org/
+//	  source:
+//	    dir: https://github.com/9fans/go/tree/main{/dir}
+//	    file: https://github.com/9fans/go/blob/main{/dir}/{file}#L{line}
+//
+// An entry with no-docs set serves only its meta tags, without a documentation redirect, such as
+// for internal packages with no public documentation:
+//
+//	# redirects.yaml
+//	- import: internal.example.com/*
+//	  repo: https://git.example.com/internal/*
+//	  no-docs: true
+//
+// An entry's message, if set, is shown to browsers on its page in place of the text linking to its
+// documentation:
+//
+//	# redirects.yaml
+//	- import: example.com/old
+//	  repo: https://github.com/example/old
+//	  message: This package is in maintenance mode; see the README.
+//
+// An entry being retired may give the date it was deprecated, and optionally the date after which
+// it may no longer be served and the URL of its successor. These are sent in Deprecation, Sunset,
+// and Link headers on every response for the entry:
+//
+//	# redirects.yaml
+//	- import: example.com/old
+//	  repo: https://github.com/example/old
+//	  deprecated:
+//	    date: 2026-06-01
+//	    sunset: 2027-01-01
+//	    successor: https://example.com/new
+//
+// An entry may also list sub-routes, each serving a path under the entry's import path from a
+// repo of its own, such as packages kept in a legacy repository under a shared root. A sub-route
+// uses the VCS of its entry unless it sets its own, and the entry's documentation base URL. Each
+// is served as an import path of its own, so the most specific one matching a request is used:
+//
+//	# redirects.yaml
+//	- import: example.com/*
+//	  repo: https://github.com/example/*
+//	  routes:
+//	    - path: legacy
+//	      repo: https://hg.example.com/legacy
+//	      vcs: hg
+//
+// Errors are reported with the file name and the line of the offending entry.
+func loadConfig(path string, opts *redirector.Options) ([]*redirector.Redirect, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+
+	list := doc.Content[0]
+	if list.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("%s:%d: config must be a list of redirects", path, list.Line)
+	}
+	redirects := make([]*redirector.Redirect, 0, len(list.Content))
+	for _, item := range list.Content {
+		var e configEntry
+		if err := item.Decode(&e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, item.Line, err)
+		}
+		rs, err := redirector.NewRedirects(e.entry(), opts)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: error creating redirect %s -> %s: %v", path, item.Line, e.Import, e.Repo, err)
+		}
+		redirects = append(redirects, rs...)
+	}
+	return redirects, nil
+}
+
+// entry returns the redirector.Entry described by e.
+func (e *configEntry) entry() redirector.Entry {
+	entry := redirector.Entry{
+		ImportPath: e.Import,
+		Repo:       e.Repo,
+		VCS:        e.VCS,
+		Docs:       e.Docs,
+		NoDocs:     e.NoDocs,
+		Message:    e.Message,
+		Depth:      e.Depth,
+		SourceDir:  e.Source.Dir,
+		SourceFile: e.Source.File,
+		Deprecated: e.Deprecated.Date,
+		Sunset:     e.Deprecated.Sunset,
+		Successor:  e.Deprecated.Successor,
+	}
+	for _, route := range e.Routes {
+		entry.Routes = append(entry.Routes, redirector.Route{Path: route.Path, Repo: route.Repo, VCS: route.VCS})
+	}
+	return entry
+}
+
+// readPairs reads redirects from r, which holds an import path and repo URL per line separated by
+// whitespace, as would be given on the command line, optionally followed by a documentation base
+// URL for the entry. Blank lines and lines beginning with # are ignored. Errors are reported with
+// name and the line number.
+func readPairs(r io.Reader, name string, opts *redirector.Options) ([]*redirector.Redirect, error) {
+	var redirects []*redirector.Redirect
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 && len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: expected an import path, repo, and optional docs base, got %d field(s)", name, line, len(fields))
+		}
+		e := redirector.Entry{ImportPath: fields[0], Repo: fields[1]}
+		if len(fields) == 3 {
+			e.Docs = fields[2]
+		}
+		r, err := redirector.NewRedirect(e, opts)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: error creating redirect %s -> %s: %v", name, line, fields[0], fields[1], err)
+		}
+		redirects = append(redirects, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return redirects, nil
+}
@@ -0,0 +1,326 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"go.spiff.io/go-import-redirector/redirector"
+)
+
+// newRouter returns a router for redirects.
+func newRouter(t *testing.T, redirects []*redirector.Redirect) http.Handler {
+	t.Helper()
+	rt, err := redirector.NewRouter(redirects, &redirector.Options{})
+	if err != nil {
+		t.Fatalf("NewRouter failed: %v", err)
+	}
+	return rt
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	file := writeFile(t, dir, "redirects.yaml", `# redirects.yaml
+- import: rsc.io/*
+  repo: https://github.com/rsc/*
+- import: 9fans.net/go
+  repo: https://github.com/9fans/go
+  vcs: git
+- import: example.com/hg
+  repo: hg+https://hg.example.com/hg
+- import: example.com/internal
+  repo: https://git.example.com/internal
+  no-docs: true
+  message: Internal use only.
+`)
+	redirects, err := loadConfig(file, &redirector.Options{DocsBase: "https://pkg.go.dev/"})
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	if len(redirects) != 4 {
+		t.Fatalf("loadConfig returned %d redirect(s), want 4", len(redirects))
+	}
+	h := newRouter(t, redirects)
+	tests := []struct {
+		target string
+		meta   string
+	}{
+		{"rsc.io/pdf", "rsc.io/pdf git https://github.com/rsc/pdf"},
+		{"9fans.net/go/draw", "9fans.net/go git https://github.com/9fans/go"},
+		{"example.com/hg", "example.com/hg hg https://hg.example.com/hg"},
+		{"example.com/internal", "example.com/internal git https://git.example.com/internal"},
+	}
+	for _, tt := range tests {
+		w := serve(h, tt.target+"?go-get=1")
+		if meta := goImport(w.Body.String()); meta != tt.meta {
+			t.Errorf("GET %s: go-import = %q, want %q", tt.target, meta, tt.meta)
+		}
+	}
+	// Only the entry with no-docs set has no documentation redirect.
+	for target, want := range map[string]string{
+		"example.com/hg":       "https://pkg.go.dev/example.com/hg",
+		"example.com/internal": "",
+	} {
+		if docs := refresh(serve(h, target).Body.String()); docs != want {
+			t.Errorf("GET %s: refresh = %q, want %q", target, docs, want)
+		}
+	}
+	if body := serve(h, "example.com/internal").Body.String(); !strings.Contains(body, "<p>Internal use only.</p>") {
+		t.Errorf("GET example.com/internal: body %q does not show the entry's message", body)
+	}
+}
+
+func TestLoadConfigRoutes(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	file := writeFile(t, dir, "redirects.yaml", `# redirects.yaml
+- import: example.com/*
+  repo: https://github.com/example/*
+  routes:
+    - path: legacy
+      repo: https://hg.example.com/legacy
+      vcs: hg
+    - path: tools
+      repo: https://gitlab.com/example/tools
+`)
+	redirects, err := loadConfig(file, &redirector.Options{})
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	if len(redirects) != 3 {
+		t.Fatalf("loadConfig returned %d redirect(s), want 3", len(redirects))
+	}
+	h := newRouter(t, redirects)
+	tests := []struct {
+		target string
+		meta   string
+	}{
+		{"example.com/pkg", "example.com/pkg git https://github.com/example/pkg"},
+		{"example.com/legacy/sub", "example.com/legacy hg https://hg.example.com/legacy"},
+		{"example.com/tools", "example.com/tools git https://gitlab.com/example/tools"},
+	}
+	for _, tt := range tests {
+		w := serve(h, tt.target+"?go-get=1")
+		if meta := goImport(w.Body.String()); meta != tt.meta {
+			t.Errorf("GET %s: go-import = %q, want %q", tt.target, meta, tt.meta)
+		}
+	}
+}
+
+func TestLoadConfigDeprecated(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	file := writeFile(t, dir, "redirects.yaml", `# redirects.yaml
+- import: example.com/new
+  repo: https://github.com/example/new
+- import: example.com/old
+  repo: https://github.com/example/old
+  deprecated:
+    date: 2026-06-01
+    sunset: 2027-01-01
+    successor: https://example.com/new
+`)
+	redirects, err := loadConfig(file, &redirector.Options{})
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	h := newRouter(t, redirects)
+	tests := []struct {
+		target string
+		header http.Header
+	}{
+		{"example.com/new", http.Header{}},
+		{"example.com/old", http.Header{
+			"Deprecation": {"@1780272000"},
+			"Sunset":      {"Fri, 01 Jan 2027 00:00:00 GMT"},
+			"Link":        {`<https://example.com/new>; rel="successor-version"`},
+		}},
+	}
+	for _, tt := range tests {
+		w := serve(h, tt.target+"?go-get=1")
+		for _, name := range []string{"Deprecation", "Sunset", "Link"} {
+			if got, want := w.Header().Get(name), tt.header.Get(name); got != want {
+				t.Errorf("GET %s: %s = %q, want %q", tt.target, name, got, want)
+			}
+		}
+	}
+
+	writeFile(t, dir, "redirects.yaml", `- import: example.com/old
+  repo: https://github.com/example/old
+  deprecated:
+    sunset: 2027-01-01
+`)
+	if _, err := loadConfig(file, &redirector.Options{}); err == nil || !strings.Contains(err.Error(), "require a deprecation date") {
+		t.Errorf("loadConfig with a sunset and no deprecation date = %v, want an error", err)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	tests := []struct {
+		config string
+		err    string
+	}{
+		{"", ""},
+		{"import: rsc.io/*\n", "bad.yaml:1: config must be a list"},
+		{"- import: rsc.io/*\n  repo: https://github.com/rsc/*\n- import: rsc.io/pdf\n  repo: [x]\n", "bad.yaml:3:"},
+		{"- import: rsc.io/*\n  repo: https://github.com/rsc/*\n\n- import: 9fans.net/go\n", "bad.yaml:4: error creating redirect 9fans.net/go"},
+		{"- [", "bad.yaml:"},
+	}
+	for _, tt := range tests {
+		file := writeFile(t, dir, "bad.yaml", tt.config)
+		_, err := loadConfig(file, &redirector.Options{})
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("loadConfig(%q) failed: %v", tt.config, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("loadConfig(%q) = %v, want an error containing %q", tt.config, err, tt.err)
+		}
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	defer func(file string) { *configFile = file }(*configFile)
+	*configFile = writeFile(t, dir, "redirects.yaml", "- import: rsc.io/*\n  repo: https://github.com/rsc/*\n")
+
+	opts := &redirector.Options{}
+	redirects, err := loadConfig(*configFile, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt, err := buildRouter(redirects, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes := new(routerSwitch)
+	routes.store(rt)
+	check := func(target, meta string) {
+		t.Helper()
+		if got := goImport(serve(routes, target+"?go-get=1").Body.String()); got != meta {
+			t.Errorf("GET %s: go-import = %q, want %q", target, got, meta)
+		}
+	}
+	check("rsc.io/pdf", "rsc.io/pdf git https://github.com/rsc/pdf")
+	check("9fans.net/go", "")
+
+	writeFile(t, dir, "redirects.yaml", "- import: 9fans.net/go\n  repo: https://github.com/9fans/go\n")
+	reloadConfig(routes, opts)
+	check("rsc.io/pdf", "")
+	check("9fans.net/go", "9fans.net/go git https://github.com/9fans/go")
+
+	// A broken config leaves the current redirects in place.
+	writeFile(t, dir, "redirects.yaml", "- import: rsc.io/*\n")
+	reloadConfig(routes, opts)
+	check("9fans.net/go", "9fans.net/go git https://github.com/9fans/go")
+}
+
+func TestReloadOnSIGHUP(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	config := writeFile(t, dir, "redirects.yaml", "- import: rsc.io/*\n  repo: https://github.com/rsc/*\n")
+	sock := filepath.Join(dir, "redirector.sock")
+	s := startMain(t, sock, nil, "-listen=unix:"+sock, "-config="+config)
+	defer s.stop(t, syscall.SIGTERM)
+	client := unixClient(sock)
+
+	writeFile(t, dir, "redirects.yaml", "- import: rsc.io/*\n  repo: https://gitlab.com/rsc/*\n")
+	if err := s.cmd.Process.Signal(syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	want := "rsc.io/pdf git https://gitlab.com/rsc/pdf"
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		_, body := fetch(t, client, "http://rsc.io/pdf?go-get=1")
+		meta := goImport(body)
+		if meta == want {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("after SIGHUP: go-import = %q, want %q", meta, want)
+		}
+	}
+}
+
+func TestLoadConfigDepth(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	file := writeFile(t, dir, "redirects.yaml", `- import: example.com/*
+  repo: https://gitlab.com/*
+  depth: 2
+`)
+	redirects, err := loadConfig(file, &redirector.Options{})
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	w := serve(newRouter(t, redirects), "example.com/group/project/pkg?go-get=1")
+	if meta, want := goImport(w.Body.String()), "example.com/group/project git https://gitlab.com/group/project"; meta != want {
+		t.Errorf("GET example.com/group/project/pkg: go-import = %q, want %q", meta, want)
+	}
+}
+
+func TestReadPairs(t *testing.T) {
+	input := `# redirects
+rsc.io/*  https://github.com/rsc/*
+
+	9fans.net/go https://github.com/9fans/go https://godoc.org/
+`
+	redirects, err := readPairs(strings.NewReader(input), "stdin", &redirector.Options{DocsBase: "https://pkg.go.dev/"})
+	if err != nil {
+		t.Fatalf("readPairs failed: %v", err)
+	}
+	if len(redirects) != 2 {
+		t.Fatalf("readPairs returned %d redirect(s), want 2", len(redirects))
+	}
+	h := newRouter(t, redirects)
+	if meta, want := goImport(serve(h, "rsc.io/pdf?go-get=1").Body.String()), "rsc.io/pdf git https://github.com/rsc/pdf"; meta != want {
+		t.Errorf("GET rsc.io/pdf: go-import = %q, want %q", meta, want)
+	}
+	if body, want := serve(h, "9fans.net/go/draw").Body.String(), `url=https://godoc.org/9fans.net/go/draw"`; !strings.Contains(body, want) {
+		t.Errorf("GET 9fans.net/go/draw: body %q does not contain %s", body, want)
+	}
+
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{"rsc.io/* https://github.com/rsc/*\nrsc.io/pdf\n", "stdin:2: expected an import path, repo, and optional docs base, got 1 field(s)"},
+		{"\n# comment\nrsc.io/* https://github.com/rsc\n", "stdin:3: error creating redirect rsc.io/* -> https://github.com/rsc:"},
+		{"rsc.io/pdf https://github.com/rsc/pdf not-a-url\n", "stdin:1:"},
+	}
+	for _, tt := range tests {
+		_, err := readPairs(strings.NewReader(tt.input), "stdin", &redirector.Options{})
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("readPairs(%q) = %v, want an error containing %q", tt.input, err, tt.err)
+		}
+	}
+}
+
+func TestStdin(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	sock := filepath.Join(dir, "redirector.sock")
+	// Pairs read from stdin are served alongside those given as arguments.
+	cmd := command(nil, "-listen=unix:"+sock, "-stdin", "9fans.net/go", "https://github.com/9fans/go")
+	cmd.Stdin = strings.NewReader("rsc.io/* https://github.com/rsc/*\n")
+	s := startCommand(t, unixClient(sock), cmd)
+	defer s.stop(t, syscall.SIGTERM)
+	client := unixClient(sock)
+	for target, want := range map[string]string{
+		"rsc.io/pdf":   "rsc.io/pdf git https://github.com/rsc/pdf",
+		"9fans.net/go": "9fans.net/go git https://github.com/9fans/go",
+	} {
+		_, body := fetch(t, client, "http://"+target+"?go-get=1")
+		if meta := goImport(body); meta != want {
+			t.Errorf("GET %s: go-import = %q, want %q", target, meta, want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// parseCIDRs parses each of cidrs as a CIDR range. A bare IP address is treated as a range
+// containing only that address.
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, &net.ParseError{Type: "IP address", Text: cidr}
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipnet)
+	}
+	return nets, nil
+}
+
+// forwarded returns a handler that applies the X-Forwarded-For, X-Forwarded-Host, and
+// X-Forwarded-Proto headers of requests from trusted proxies before passing them to next. The
+// forwarded client address and host replace the request's RemoteAddr and Host, and the scheme is
+// kept in its context for requestScheme. The URL is left alone, since the URL of a server request
+// has no scheme. Requests from any other address are passed to next unchanged. If all is true, the
+// headers of every request are trusted, including those received on a unix socket.
+func forwarded(next http.Handler, trusted []*net.IPNet, all bool) http.Handler {
+	if all {
+		trusted = allNets
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !all && !inNets(trusted, remoteIP(req.RemoteAddr)) {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		ctx := req.Context()
+		if proto := firstValue(req.Header.Get("X-Forwarded-Proto")); proto != "" {
+			ctx = context.WithValue(ctx, schemeKey{}, strings.ToLower(proto))
+		}
+		fwd := req.WithContext(ctx)
+		if host := firstValue(req.Header.Get("X-Forwarded-Host")); host != "" {
+			fwd.Host = host
+		}
+		if client := forwardedClient(req.Header.Get("X-Forwarded-For"), trusted); client != nil {
+			fwd.RemoteAddr = net.JoinHostPort(client.String(), "0")
+		}
+		next.ServeHTTP(w, fwd)
+	})
+}
+
+type schemeKey struct{}
+
+// forwardedScheme returns the scheme forwarded by a trusted proxy for req, or "" if there is none.
+func forwardedScheme(req *http.Request) string {
+	scheme, _ := req.Context().Value(schemeKey{}).(string)
+	return scheme
+}
+
+// forwardedClient returns the client address from an X-Forwarded-For header. Since each proxy
+// appends the address it received the request from, this is the last address in the list that
+// isn't a trusted proxy, or the first address if all of them are. It returns nil if the header is
+// empty or malformed.
+func forwardedClient(header string, trusted []*net.IPNet) net.IP {
+	addrs := strings.Split(header, ",")
+	var ip net.IP
+	for i := len(addrs) - 1; i >= 0; i-- {
+		ip = net.ParseIP(strings.TrimSpace(addrs[i]))
+		if ip == nil {
+			return nil
+		}
+		if !inNets(trusted, ip) {
+			return ip
+		}
+	}
+	return ip
+}
+
+// allNets contains every IPv4 and IPv6 address.
+var allNets = []*net.IPNet{
+	{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 8*net.IPv4len)},
+	{IP: net.IPv6zero, Mask: net.CIDRMask(0, 8*net.IPv6len)},
+}
+
+// firstValue returns the first value of a comma-separated header.
+func firstValue(header string) string {
+	if i := strings.IndexByte(header, ','); i >= 0 {
+		header = header[:i]
+	}
+	return strings.TrimSpace(header)
+}
+
+// remoteIP returns the IP address of a request's RemoteAddr, or nil if it has none.
+func remoteIP(remoteAddr string) net.IP {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// inNets returns whether ip is in any of nets.
+func inNets(nets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
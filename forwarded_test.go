@@ -0,0 +1,149 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseCIDRs(t *testing.T) {
+	nets, err := parseCIDRs([]string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/32", "::1"})
+	if err != nil {
+		t.Fatalf("parseCIDRs failed: %v", err)
+	}
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"192.0.2.1", true},
+		{"192.0.2.2", false},
+		{"2001:db8::1", true},
+		{"::1", true},
+		{"::2", false},
+		{"203.0.113.1", false},
+	}
+	for _, tt := range tests {
+		if got := inNets(nets, remoteIP(tt.ip)); got != tt.want {
+			t.Errorf("%s in %v = %t, want %t", tt.ip, nets, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"10.0.0.0/33", "example.com"} {
+		if _, err := parseCIDRs([]string{bad}); err == nil {
+			t.Errorf("parseCIDRs(%q) succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestForwarded(t *testing.T) {
+	trusted, err := parseCIDRs([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var host, remoteAddr, scheme string
+	h := forwarded(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host, remoteAddr, scheme = req.Host, req.RemoteAddr, requestScheme(req)
+	}), trusted, false)
+
+	tests := []struct {
+		remoteAddr string
+		forwardFor string
+		host       string
+		clientAddr string
+		scheme     string
+	}{
+		// Headers from a trusted proxy are applied.
+		{"10.0.0.1:1234", "198.51.100.7", "public.example.com", "198.51.100.7:0", "https"},
+		// The client is the last untrusted address, skipping other trusted proxies.
+		{"10.0.0.1:1234", "203.0.113.9, 198.51.100.7, 10.0.0.2", "public.example.com", "198.51.100.7:0", "https"},
+		// Headers from anyone else are ignored.
+		{"192.0.2.1:1234", "198.51.100.7", "internal.example.com", "192.0.2.1:1234", "http"},
+		// As are the headers of requests from unix sockets, which have no address.
+		{"@", "198.51.100.7", "internal.example.com", "@", "http"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "http://internal.example.com/pkg", nil)
+		req.RemoteAddr = tt.remoteAddr
+		req.Header.Set("X-Forwarded-For", tt.forwardFor)
+		req.Header.Set("X-Forwarded-Host", "public.example.com")
+		req.Header.Set("X-Forwarded-Proto", "HTTPS")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if host != tt.host || remoteAddr != tt.clientAddr || scheme != tt.scheme {
+			t.Errorf("request from %s forwarded for %q: host, address, scheme = %q, %q, %q; want %q, %q, %q",
+				tt.remoteAddr, tt.forwardFor, host, remoteAddr, scheme, tt.host, tt.clientAddr, tt.scheme)
+		}
+	}
+}
+
+func TestForwardedCleanPath(t *testing.T) {
+	h := forwarded(newTestRouter(t, "example.com/pkg", "https://github.com/example/pkg"), nil, true)
+	req := httptest.NewRequest(http.MethodGet, "http://internal.example.com//pkg/?go-get=1", nil)
+	req.Header.Set("X-Forwarded-Host", "example.com")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	// The redirect is relative, so the client stays on the scheme and host it requested.
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/pkg/?go-get=1" {
+		t.Errorf("GET //pkg/ = %d to %q, want %d to %q",
+			w.Code, w.Header().Get("Location"), http.StatusMovedPermanently, "/pkg/?go-get=1")
+	}
+}
+
+func TestForwardedHost(t *testing.T) {
+	router := newTestRouter(t, "rsc.io/*", "https://github.com/rsc/*")
+	tests := []struct {
+		trust bool
+		host  string
+		code  int
+	}{
+		{true, "rsc.io", http.StatusOK},
+		{true, "rsc.io:443, proxy.internal", http.StatusOK},
+		{true, "example.com", http.StatusNotFound},
+		// Without -trust-forwarded, the header is ignored.
+		{false, "rsc.io", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		h := forwarded(router, nil, tt.trust)
+		req := httptest.NewRequest(http.MethodGet, "http://10.0.0.1:8080/pdf?go-get=1", nil)
+		req.Header.Set("X-Forwarded-Host", tt.host)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != tt.code {
+			t.Errorf("GET /pdf forwarded for %q (trusted: %t): status = %d, want %d", tt.host, tt.trust, w.Code, tt.code)
+		}
+	}
+}
+
+func TestRedirectHTTPS(t *testing.T) {
+	h := forwarded(redirectHTTPS(newTestRouter(t, "rsc.io/*", "https://github.com/rsc/*"), "/healthz"), nil, true)
+	tests := []struct {
+		target string
+		proto  string
+		code   int
+		loc    string
+	}{
+		{"rsc.io/pdf", "", http.StatusMovedPermanently, "https://rsc.io/pdf"},
+		{"rsc.io:8080/pdf?x=1", "", http.StatusMovedPermanently, "https://rsc.io/pdf?x=1"},
+		{"[::1]:8080/pdf", "", http.StatusMovedPermanently, "https://[::1]/pdf"},
+		// go get is answered over plain HTTP.
+		{"rsc.io/pdf?go-get=1", "", http.StatusOK, ""},
+		// As are requests a proxy received over HTTPS, and health checks.
+		{"rsc.io/pdf", "https", http.StatusOK, ""},
+		{"lb.internal/healthz", "", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "http://"+tt.target, nil)
+		if tt.proto != "" {
+			req.Header.Set("X-Forwarded-Proto", tt.proto)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if loc := w.Header().Get("Location"); w.Code != tt.code || loc != tt.loc {
+			t.Errorf("GET %s (forwarded proto %q) = %d to %q, want %d to %q", tt.target, tt.proto, w.Code, loc, tt.code, tt.loc)
+		}
+	}
+}
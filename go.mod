@@ -1,8 +1,24 @@
 module go.spiff.io/go-import-redirector
 
-go 1.12
+go 1.18
 
 require (
-	golang.org/x/sync v0.0.0-20190412183630-56d357773e84
-	golang.org/x/sys v0.0.0-20190412213103-97732733099d
+	github.com/prometheus/client_golang v1.7.1
+	golang.org/x/crypto v0.21.0
+	golang.org/x/net v0.23.0
+	golang.org/x/sync v0.1.0
+	golang.org/x/sys v0.18.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.1.1 // indirect
+	github.com/golang/protobuf v1.4.2 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
+	github.com/prometheus/client_model v0.2.0 // indirect
+	github.com/prometheus/common v0.10.0 // indirect
+	github.com/prometheus/procfs v0.1.3 // indirect
+	golang.org/x/text v0.14.0 // indirect
+	google.golang.org/protobuf v1.23.0 // indirect
 )
@@ -0,0 +1,95 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"log"
+	"log/syslog"
+	"os"
+	"sync"
+)
+
+var (
+	// logOut is the log file opened by setLogOutput, if any.
+	logOut *logFile
+	// logWriter is where the standard logger writes, as set by setLogOutput.
+	logWriter io.Writer = os.Stderr
+)
+
+// setLogOutput directs the standard logger to dest, which may be stderr, stdout, syslog, or the
+// path of a file to append to.
+func setLogOutput(dest string) error {
+	var w io.Writer
+	switch dest {
+	case "", "stderr":
+		w = os.Stderr
+	case "stdout":
+		w = os.Stdout
+	case "syslog":
+		sw, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, "go-import-redirector")
+		if err != nil {
+			return err
+		}
+		// Syslog records its own timestamp and tag.
+		log.SetFlags(0)
+		log.SetPrefix("")
+		w = sw
+	default:
+		f, err := openLogFile(dest)
+		if err != nil {
+			return err
+		}
+		logOut = f
+		w = f
+	}
+	log.SetOutput(w)
+	logWriter = w
+	return nil
+}
+
+// reopenLog reopens the log file, if logging to one. This is used after the file has been moved
+// away by log rotation, so that logs are written to a new file at the original path.
+func reopenLog() error {
+	if logOut == nil {
+		return nil
+	}
+	return logOut.reopen()
+}
+
+// logFile is a log file that can be reopened at the same path.
+type logFile struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+func openLogFile(path string) (*logFile, error) {
+	l := &logFile{path: path}
+	if err := l.reopen(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *logFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Write(p)
+}
+
+func (l *logFile) reopen() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	old := l.file
+	l.file = f
+	l.mu.Unlock()
+	if old != nil {
+		return old.Close()
+	}
+	return nil
+}
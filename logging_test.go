@@ -0,0 +1,137 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// readFile returns the content of file.
+func readFile(t *testing.T, file string) string {
+	t.Helper()
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestReopenLog(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	logPath := filepath.Join(dir, "redirector.log")
+	rotated := filepath.Join(dir, "redirector.log.1")
+
+	f, err := openLogFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.file.Close()
+	defer func(l *logFile) { logOut = l }(logOut)
+	logOut = f
+
+	io.WriteString(f, "before\n")
+	if err := os.Rename(logPath, rotated); err != nil {
+		t.Fatal(err)
+	}
+	// Until the log is reopened, writes go to the rotated file.
+	io.WriteString(f, "during\n")
+	if err := reopenLog(); err != nil {
+		t.Fatalf("reopenLog() failed: %v", err)
+	}
+	io.WriteString(f, "after\n")
+
+	if got, want := readFile(t, rotated), "before\nduring\n"; got != want {
+		t.Errorf("rotated log = %q, want %q", got, want)
+	}
+	if got, want := readFile(t, logPath), "after\n"; got != want {
+		t.Errorf("reopened log = %q, want %q", got, want)
+	}
+}
+
+func TestReopenLogWithoutFile(t *testing.T) {
+	defer func(l *logFile) { logOut = l }(logOut)
+	logOut = nil
+	if err := reopenLog(); err != nil {
+		t.Errorf("reopenLog() without a log file failed: %v", err)
+	}
+}
+
+func TestLogOutput(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	sock := filepath.Join(dir, "redirector.sock")
+	logPath := filepath.Join(dir, "redirector.log")
+	rotated := filepath.Join(dir, "redirector.log.1")
+	client := unixClient(sock)
+
+	// Logs go to the file, and after a SIGHUP to a new file in place of the rotated one.
+	s := startMain(t, sock, nil, "-listen=unix:"+sock, "-log-output="+logPath, "-log-format=text",
+		"rsc.io/*", "https://github.com/rsc/*")
+	fetch(t, client, "http://rsc.io/before?go-get=1")
+	if err := os.Rename(logPath, rotated); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.cmd.Process.Signal(syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(logPath); err == nil {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("log file %s not reopened after SIGHUP", logPath)
+		}
+	}
+	fetch(t, client, "http://rsc.io/after?go-get=1")
+	if out := s.stop(t, syscall.SIGTERM); out != "" {
+		t.Errorf("server wrote %q to stderr, want logs only in %s", out, logPath)
+	}
+	if got := readFile(t, rotated); !strings.Contains(got, "/before") || strings.Contains(got, "/after") {
+		t.Errorf("rotated log = %q, want only the request before SIGHUP", got)
+	}
+	if got := readFile(t, logPath); strings.Contains(got, "/before") || !strings.Contains(got, "/after") || !strings.Contains(got, "shutting down") {
+		t.Errorf("reopened log = %q, want the request after SIGHUP and the shutdown", got)
+	}
+
+	// Logs go to stdout, leaving stderr empty.
+	var stdout, stderr bytes.Buffer
+	cmd := command(nil, "-listen=unix:"+sock, "-log-output=stdout", "rsc.io/*", "https://github.com/rsc/*")
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		resp, err := client.Get("http://rsc.io/pdf")
+		if err == nil {
+			resp.Body.Close()
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			cmd.Process.Kill()
+			cmd.Wait()
+			t.Fatalf("server did not start: %v", err)
+		}
+	}
+	cmd.Process.Signal(syscall.SIGTERM)
+	if err := cmd.Wait(); err != nil {
+		t.Errorf("server exited with an error: %v\n%s", err, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "shutting down") || stderr.Len() > 0 {
+		t.Errorf("with -log-output=stdout, stdout = %q and stderr = %q", stdout.String(), stderr.String())
+	}
+
+	out, err := runMain(t, nil, "-log-output="+filepath.Join(dir, "missing", "redirector.log"), "rsc.io/*", "https://github.com/rsc/*")
+	if err == nil || !strings.Contains(out, "error opening log output") {
+		t.Errorf("running with a log file in a missing directory = %v, %q; want an error", err, out)
+	}
+}
@@ -3,18 +3,91 @@
 
 // Go-import-redirector is an HTTP server for a custom Go import domain. It responds to requests in
 // a given import path root with a meta tag specifying the source repository for the ``go get''
-// command and an HTML redirect to the godoc.org documentation page for that package.
+// command and an HTML redirect to the documentation page for that package.
 //
 // Usage:
 //
 //	go-import-redirector [-listen address] [-grace period] [-vcs sys] <import> <repo> ...
+//	go-import-redirector [-listen address] [-grace period] [-vcs sys] -config file
+//	go-import-redirector [-listen address] [-grace period] [-vcs sys] -stdin [<import> <repo> ...]
 //
 // Go-import-redirector listens on an address (default ``:9001'') and responds to requests for URLs
 // in one of the the given import path roots with one meta tag specifying the given source
-// repository for ``go get'' and another meta tag causing a redirect to the corresponding godoc.org
-// documentation page.
+// repository for ``go get'' and another meta tag causing a redirect to the corresponding
+// documentation page on pkg.go.dev.
 //
-// Multiple pairs of import paths and repository URLs may be specified.
+// Multiple pairs of import paths and repository URLs may be specified, up to the limit set by the
+// -max-rules option (default 10000). Where import paths overlap, such as example.com/* and
+// example.com/foo, a request is served by the redirect with the longest matching import path.
+// An import path of a bare host, such as example.com, thus catches every path on the host that no
+// longer import path matches, serving it from a single repository.
+//
+// The host of an import path is matched without regard to case, as in DNS, so a request for
+// Rsc.IO/x86 is served by rsc.io/*. The rest of the path is matched exactly, since import paths are
+// case-sensitive.
+//
+// The handlers used to serve redirects are available to other programs in the package
+// go.spiff.io/go-import-redirector/redirector.
+//
+// The -stdin option reads further pairs from standard input, one import path and repository URL
+// per line separated by whitespace, after any given on the command line. A line may end with a
+// documentation base URL for its entry, used in place of -docs. Blank lines and lines beginning
+// with # are ignored.
+//
+// Alternatively, the -config option names a YAML file listing the import paths and repository URLs
+// to serve, in which case no pairs may be given on the command line or with -stdin. Each entry may
+// also set the version control system used when its repository URL has no VCS prefix, and its own
+// documentation base URL (see -docs):
+//
+//	# redirects.yaml
+//	- import: rsc.io/*
+//	  repo: https://github.com/rsc/*
+//	- import: 9fans.net/go
+//	  repo: https://hg.example.com/9fans
+//	  vcs: hg
+//	  docs: https://godoc.org/
+//
+// An entry may also give URL templates for source directories and files, which are served in a
+// go-source meta tag for tools that link to source code. In these, {elem} is replaced with the
+// wildcard element of the request, {ref} with the ref given in the repo URL, if any, and {/dir},
+// {file}, and {line} are left for those tools to fill:
+//
+//	# redirects.yaml
+//	- import: rsc.io/*
+//	  repo: https://github.com/rsc/*
+//	  source:
+//	    dir: https://github.com/rsc/{elem}/tree/master{/dir}
+//	    file: https://github.com/rsc/{elem}/blob/master{/dir}/{file}#L{line}
+//
+// An entry may list sub-routes serving paths under its import path from other repositories, such
+// as packages kept in a legacy repository under a shared root. Each sub-route uses the version
+// control system of its entry unless it sets its own, and is served as an import path of its own,
+// so the most specific one matching a request is used. Under a subdomain wildcard (see below), a *
+// in a sub-route's repo path is replaced with the subdomain label, as for the entry itself:
+//
+//	# redirects.yaml
+//	- import: example.com/*
+//	  repo: https://github.com/example/*
+//	  routes:
+//	    - path: legacy
+//	      repo: https://hg.example.com/legacy
+//	      vcs: hg
+//
+// An entry with no-docs: true is served without a documentation redirect whatever -docs is set to,
+// for internal packages with no public documentation. An entry's message, if set, is shown to
+// browsers on its page in place of the text linking to its documentation.
+//
+// An entry being retired may give the date it was deprecated, and optionally its sunset date and
+// successor, which are sent in Deprecation, Sunset (RFC 8594), and Link rel="successor-version"
+// headers on every response for it:
+//
+//	# redirects.yaml
+//	- import: example.com/old
+//	  repo: https://github.com/example/old
+//	  deprecated:
+//	    date: 2026-06-01
+//	    sunset: 2027-01-01
+//	    successor: https://example.com/new
 //
 // For example, if invoked as:
 //
@@ -23,7 +96,7 @@
 // then the response for 9fans.net/go/acme/editinacme will include these tags:
 //
 //	<meta name="go-import" content="9fans.net/go git https://github.com/9fans/go">
-//	<meta http-equiv="refresh" content="0; url=https://godoc.org/9fans.net/go/acme/editinacme">
+//	<meta http-equiv="refresh" content="0; url=https://pkg.go.dev/9fans.net/go/acme/editinacme">
 //
 // If both <import> and <repo> end in /*, the corresponding path element is taken from the import
 // path and substituted in repo on each request. For example, if invoked as:
@@ -33,48 +106,494 @@
 // then the response for rsc.io/x86/x86asm will include these tags:
 //
 //	<meta name="go-import" content="rsc.io/x86 git https://github.com/rsc/x86">
-//	<meta http-equiv="refresh" content="0; url=https://godoc.org/rsc.io/x86/x86asm">
+//	<meta http-equiv="refresh" content="0; url=https://pkg.go.dev/rsc.io/x86/x86asm">
 //
 // Note that the wildcard element (x86) has been included in the Git repo path.
 //
-// The -listen option specifies the address to serve from (default ``:9001'').
+// Rather than ending in /*, the repo of a wildcard may place the wildcard element anywhere in its
+// path with an {elem} placeholder. For example, if invoked as:
+//
+//	go-import-redirector rsc.io/* https://git.example.com/{elem}.git
+//
+// then rsc.io/x86/x86asm uses the repository https://git.example.com/x86.git. The /* form is
+// shorthand for a trailing /{elem}.
+//
+// The -wildcard-depth option sets the number of path elements taken from the import path for a
+// wildcard (default 1). This allows hosts with nested groups to be served; for example, if invoked
+// as:
+//
+//	go-import-redirector -wildcard-depth 2 example.com/* https://gitlab.com/*
+//
+// then example.com/group/project/pkg uses the repository https://gitlab.com/group/project with the
+// import root example.com/group/project. Requests with fewer elements than the depth are not found.
+// An entry in a -config file may set its own depth, for hosts whose groups are nested to different
+// depths:
+//
+//	# redirects.yaml
+//	- import: example.com/*
+//	  repo: https://gitlab.com/*
+//	  depth: 2
+//
+// If both <import> and <repo> end in /**, the full remaining path of a request is taken for the
+// wildcard, for hosts with groups nested to any depth. For example, if invoked as:
+//
+//	go-import-redirector example.com/** https://gitlab.com/**
+//
+// then example.com/group/subgroup/project uses the repository
+// https://gitlab.com/group/subgroup/project with the import root
+// example.com/group/subgroup/project. Since the request alone can't tell a project apart from a
+// package within it, the whole path is always taken as the import root, so /** can only serve
+// import paths that are module roots. A package such as example.com/group/subgroup/pkg gets the
+// import root example.com/group/subgroup/pkg and the repository
+// https://gitlab.com/group/subgroup/pkg, which doesn't exist, rather than the project
+// example.com/group/subgroup. For hosts with packages below their projects, use /* with
+// -wildcard-depth, or with a depth set for the entry in a -config file, as described above.
+//
+// If the host of <import> begins with *., each subdomain of that domain is served, and the label
+// of the requested subdomain is substituted for the one * left in the path of <repo>. This gives
+// each team or organization a subdomain of its own; for example, if invoked as:
+//
+//	go-import-redirector '*.example.com/*' 'https://github.com/*/*'
+//
+// then teamx.example.com/pkg uses the repository https://github.com/teamx/pkg. Only a single label
+// is matched, so neither example.com itself nor a.b.example.com is served. Import paths on a
+// single host, such as teamx.example.com/pkg, take precedence over subdomain wildcards.
+//
+// The -listen option specifies the address to serve from (default ``:9001''). It may be a
+// comma-separated list of addresses, such as ``:9001,unix:/run/redirector.sock'', to serve the
+// same redirects from each of them. If any address can't be listened on or served, the error is
+// logged with the address, the others are closed, and the process exits with status 1.
+// If -listen is not given and the PORT environment variable is set, as on many hosting platforms,
+// the address is ``:$PORT'' instead.
 // If the listen address begins with "unix:", then redirects are served from a Unix domain socket.
+// On Linux, a socket name beginning with @, such as "unix:@redirector", is bound in the abstract
+// socket namespace, which needs no file and no cleanup.
+// Otherwise, a stale socket file left at the path by an earlier process is removed before binding
+// if nothing is accepting connections on it, and the socket file is removed on shutdown.
+//
+// When started by systemd socket activation, with LISTEN_PID and LISTEN_FDS set in the
+// environment, redirects are served from the first socket passed by systemd and -listen is
+// ignored.
+//
+// The -tls-cert and -tls-key options name PEM-encoded certificate and private key files. When both
+// are given, redirects are served over HTTPS instead of HTTP. Otherwise, if the TLS_CERT_PEM and
+// TLS_KEY_PEM environment variables are set, they hold the PEM-encoded certificate and private key
+// themselves, such as for secrets injected into a container. Either way, the key pair is checked on
+// startup.
+//
+// The -autocert-hosts option is a comma-separated list of hosts to obtain certificates for from
+// Let's Encrypt. When given, redirects are served over HTTPS on -listen, which defaults to ``:443''
+// in this case unless PORT is set, and over HTTP on :80, which also answers the ACME HTTP-01
+// challenges used to obtain certificates. Certificates are stored in the -autocert-cache directory
+// (default ``autocert-cache''). Using -autocert-hosts implies acceptance of the Let's Encrypt
+// terms of service, and it may not be combined with -tls-cert and -tls-key.
+//
+// The -h2c option serves HTTP/2 over plain HTTP (h2c) as well as HTTP/1.1, for proxies and service
+// meshes that speak cleartext HTTP/2 to their backends. It may not be combined with TLS, which
+// already negotiates HTTP/2. On shutdown, h2c connections are told to stop sending new requests.
+//
+// The -reuseport option sets SO_REUSEPORT on the listening TCP socket, allowing multiple instances
+// of go-import-redirector to bind the same address. Linux 3.9 and newer distribute incoming
+// connections across these instances. Other BSD-derived systems accept the option but may not
+// balance connections between processes, and it is unavailable on other platforms.
+//
+// On SIGTERM, go-import-redirector stops accepting connections and waits up to the -grace
+// period (default 5s) for in-flight requests to finish before exiting. An interrupt (SIGINT) closes
+// all connections and exits immediately. Requests still running at the end of the grace period are
+// cut off, and the process exits with status 1.
+//
+// The -read-timeout, -write-timeout, and -idle-timeout options limit how long a connection may take
+// to send a request (default 10s), how long a response may take to write (default 10s), and how
+// long a keep-alive connection may sit idle between requests (default 2m), respectively. A
+// zero timeout is unlimited.
 //
-// The -vcs option specifies the default version control system, git, hg, or svn (default ``git'').
+// The -drain-mode option controls how the listener is handled during the grace period. With
+// ``close'' (the default), the listener is closed as soon as the signal is received and only
+// established connections are served. Any connections still waiting in the accept backlog are
+// dropped, so a load balancer that has not yet noticed the shutdown may see errors. With ``serve'',
+// new connections continue to be accepted (without keep-alives) for the grace period, giving a load
+// balancer time to stop sending traffic, after which the server shuts down as in close mode. This
+// can take up to twice the grace period.
+//
+// The -shutdown-drain option answers health checks at -health-path with a 503 Service Unavailable
+// as soon as a signal to shut down is received, while redirects continue to be served for the
+// grace period. Combined with -drain-mode serve, this tells a load balancer to stop sending traffic
+// before the listener is closed.
+//
+// The -vcs option specifies the default version control system (default ``git'').
 // This can be changed per-repo by beginning the repo URL with the VCS name followed by a plus
-// (``+''), such as "git+https://github.com/name/*".
+// (``+''), such as "git+https://github.com/name/*". The version control system must be one of
+// those known to ``go get'': bzr, fossil, git, hg, svn, or mod.
+//
+// The mod pseudo-VCS serves modules from a module proxy rather than a repository, so a vanity
+// domain can serve modules without one. For example, if invoked as:
+//
+//	go-import-redirector example.com/foo mod+https://proxy.example.com
+//
+// then the go command fetches example.com/foo from the proxy at https://proxy.example.com, using
+// the go-import meta tag:
+//
+//	<meta name="go-import" content="example.com/foo mod https://proxy.example.com">
+//
+// Documentation redirects are unaffected by the version control system.
+//
+// A repo URL may end in a fragment naming a branch, tag, or other ref, such as
+// "https://github.com/name/*#develop". The go-import meta tag has no field for a ref, so no version
+// control system honors it there and it is left out of the tag: ``go get'' always starts from the
+// repository's default branch and selects versions by module queries instead. The ref is given to
+// templates as Ref and replaces {ref} in go-source URL templates.
+//
+// The -vcs-alias option maps one version control name to another, given as ``from=to'', and may be
+// repeated. Aliases are applied before validation, so -vcs-alias github=git allows repo URLs such as
+// "github+https://github.com/name/*" while still emitting git in the go-import meta tag.
+//
+// The -wildcard-root option controls the response to a request for the bare root of a wildcard
+// import path, such as rsc.io when invoked with rsc.io/*. It may be one of ``docs'' (the default),
+// which redirects to the root's documentation page, ``404'', ``204'', or a URL to redirect to.
+//
+// The -root-docs option controls where browsers are sent for a request at an import root itself,
+// such as 9fans.net/go in the example above. It may be ``pkg'' (the default), to redirect to the
+// package documentation, or ``repo'', to redirect to the repository URL. Requests for subpaths
+// always redirect to the package documentation.
+//
+// The -strict-query option causes requests with any query parameters other than ``go-get=1'' to
+// be rejected with a 400 Bad Request.
+//
+// The -docs option sets the base URL of the documentation host (default ``https://pkg.go.dev/''),
+// which the import path is appended to. If it is empty, no documentation redirect or link is
+// included in the page, leaving only the go-import meta tag.
+//
+// The -godoc-legacy option redirects to documentation on godoc.org instead of pkg.go.dev, using
+// the same import path layout. It may not be combined with -docs.
+//
+// The -docs-template option sets the text/template used to build documentation URLs (default
+// ``{{.DocsBase}}{{.ImportRoot}}{{.Suffix}}''). It is executed with the docs base URL and the
+// import root, VCS, repository root, and suffix of each request, so documentation hosts with other
+// URL layouts may be used, such as ``https://deps.dev/go/{{.ImportRoot}}{{.Suffix}}''. The import
+// root and suffix are percent-encoded, so that request paths with spaces or other special
+// characters still produce valid documentation URLs.
+//
+// The -max-path-length option sets the longest request path, in bytes, that will be served
+// (default 1024). Longer paths are rejected with a 414 URI Too Long. A limit of zero disables the
+// check.
+//
+// The -strict-methods option causes requests using a method other than GET or HEAD to be rejected.
+// Other standard methods, such as POST, TRACE, and CONNECT, receive a 405 Method Not Allowed with
+// an Allow header, and unrecognized methods receive a 501 Not Implemented.
+//
+// The -template option names an html/template file used in place of the built-in page for all
+// requests. The -goget-template and -browser-template options name html/template files used instead
+// for requests from ``go get'' (those with a ``go-get=1'' query parameter) and for all other
+// requests, respectively. If only one of these is given without -template, it is used for both. A
+// template that fails to parse or refers to an unknown field is an error on startup. Templates are
+// executed with the fields ImportRoot, VCS, VCSRoot, Ref, Suffix, DocsBase, DocsURL, Refresh,
+// Canonical, JSONLD, GoSource, and Message. DocsURL is empty if documentation redirects are
+// disabled, GoSource is empty unless source URL templates are configured, and Message is empty
+// unless the entry's config sets one.
+//
+// The -notfound-template option names an html/template file used for 404 Not Found responses to
+// requests that match no import path. It is executed with the fields Host and Path, from the
+// request, and Roots, the sorted list of all import paths.
+//
+// The -manual-redirect option omits the refresh meta tag, so browsers are not automatically sent to
+// the documentation, while keeping the link to it in the page body.
+//
+// The -strict-goget option serves the page only to requests from go get. Other requests are sent
+// directly to the documentation with a 302 Found, or to the repository if documentation redirects
+// are disabled.
+//
+// The -permanent option makes direct redirects, from -strict-goget and -wildcard-root, use 301
+// Moved Permanently instead of 302 Found, allowing browsers and search engines to cache them.
+//
+// A request whose Accept header prefers application/json to HTML, by its quality value, is answered
+// with a JSON object instead of a page, for tools that look up module sources, such as:
+//
+//	{"import_root":"rsc.io/x86","vcs":"git","vcs_root":"https://github.com/rsc/x86",
+//	 "suffix":"/x86asm","docs_url":"https://pkg.go.dev/rsc.io/x86/x86asm"}
+//
+// The -cache-max-age option sets a Cache-Control header allowing clients to cache pages and direct
+// redirects for the given period, such as 1h. By default, no Cache-Control header is sent.
+//
+// The -hsts option adds a Strict-Transport-Security header to every response with the given
+// max-age, such as 8760h, so that browsers only reach the vanity domain over HTTPS. It should only
+// be used when the domain is served over TLS, directly or through a proxy. The -hsts-subdomains
+// option adds includeSubDomains to the policy. By default, no Strict-Transport-Security header is
+// sent.
+//
+// The -https-redirect option sends requests made over plain HTTP, other than those from ``go get''
+// and requests for -health-path or -metrics-path, to the same URL over HTTPS with a 301 Moved
+// Permanently. Requests from ``go get'' are still answered with the page, since it tries HTTPS
+// first and only falls back to HTTP when it can't be reached. The scheme is taken from
+// X-Forwarded-Proto for trusted proxies (see -trusted-cidr).
+//
+// The -canonical option adds a <link rel="canonical"> tag to the page pointing at the documentation
+// URL, so that search engines index the documentation rather than the redirect page.
+//
+// The -allowed-host option restricts the hosts that may be requested, and may be repeated. Requests
+// for any other host are rejected with a 421 Misdirected Request rather than a 404, making
+// misrouted traffic easy to tell apart from unknown import paths. Health checks at -health-path
+// are answered on any host.
+//
+// The -useragent-allow option is a regular expression that a request's User-Agent must match, such
+// as ``^(Go-http-client|Mozilla)/''. Requests from any other user agent, including those without
+// one, are rejected with a 403 Forbidden instead of being redirected. Unlike robots.txt, this
+// blocks scrapers rather than asking them to stay away. Health checks at -health-path are always
+// allowed.
+//
+// The -verify-repos option checks on startup that the repository of each import path exists, by
+// making a HEAD request to each HTTP or HTTPS repository URL. With ``warn'', missing repositories
+// are logged; with ``fail'', go-import-redirector exits if any repository returns a 404 or 410.
+// Network errors are logged but never cause a failure. Wildcard repositories can't be checked and
+// are skipped, as are import paths given to the repeatable -verify-skip option. The checks are
+// also made when the config is reloaded, and give up after 30 seconds so that a slow remote
+// doesn't hold up signals; repositories left unchecked are logged.
+//
+// An import path whose repo is on the same host, such as example.com/x with the repo
+// https://example.com/x, is usually a copy-paste mistake that sends ``go get'' in a loop, and is
+// logged as a warning. The -strict option makes it an error instead.
+//
+// The -log-output option sets where logs are written: ``stderr'' (the default), ``stdout'',
+// ``syslog'', or the path of a file to append to. On SIGHUP, a log file is reopened, so that logs
+// are written to a new file after the old one has been rotated away. SIGHUP also reloads the
+// -config file, if given; if the new config is invalid, the error is logged and the old one is
+// kept in use.
+//
+// The -log-format option enables an access log, written with the other logs, with a line for each
+// request giving its method, host, path, the import root and VCS root it resolved to, the response
+// status, and how long it took. With ``text'', lines are written like other log messages, and with
+// ``json'', each line is a JSON object.
+//
+// The -jsonld option adds a JSON-LD description of the package to the page, giving its name,
+// repository, and documentation URL for search engines.
+//
+// The -minify option removes whitespace between tags in the head of rendered pages, reducing their
+// size for hosts serving a large number of requests. The body is left as it is, so that a custom
+// -template renders the same either way.
+//
+// The -minimal option answers requests from ``go get'' with a page holding only the go-import
+// meta tag and any go-source tag, without the documentation redirect or body meant for browsers.
+// Other requests are served as usual. It may not be combined with -goget-template.
+//
+// The -trusted-cidr option names a proxy address range, such as 10.0.0.0/8, whose forwarded headers
+// are trusted, and may be repeated. For requests from these addresses, the X-Forwarded-Host header
+// is used in place of the Host header to match import paths, and X-Forwarded-For and
+// X-Forwarded-Proto give the client address and scheme. Forwarded headers from any other address
+// are ignored.
+//
+// The -trust-forwarded option trusts the forwarded headers of every request, for use when
+// go-import-redirector can only be reached through a proxy. It may not be combined with
+// -trusted-cidr. Without either option, forwarded headers are ignored.
+//
+// The -max-inflight option limits the number of requests handled at once. A request over the limit
+// waits up to the -inflight-wait period (default 100ms) for another to finish, and is otherwise
+// rejected with a 503 Service Unavailable. Health checks at -health-path are never limited, so that
+// a busy instance isn't taken for a dead one.
+//
+// The -health-path option sets the path answered with a plain 200 OK on any host, for use by load
+// balancers and liveness probes (default ``/healthz''). An empty path disables it. It is an error
+// for a path given with -health-path to fall under an import path's root. The default path is only
+// served on hosts where it does not, such as the host's IP address in a request from a probe.
+//
+// The -metrics-path option serves Prometheus metrics at the given path on any host, counting
+// requests, requests served by each import path, and 404 responses, and timing requests. Requests
+// are counted by import path as given, so all import roots served by a wildcard such as rsc.io/*
+// share the count for rsc.io/*. Metrics take precedence over wildcard and other import paths
+// covering the path, so that they can be served alongside rsc.io/*, but it is an error for an
+// import path to be exactly a host followed by the metrics path, such as rsc.io/metrics.
+//
+// A request with an X-Request-ID header, such as from a proxy, has its ID included in log messages
+// about the request and returned in the X-Request-ID response header. The -request-id option
+// generates a random UUID for each request without one, so that every request can be traced.
+//
+// The -major-roots option treats a major version element following an import root, such as the
+// /v2 in example.com/foo/v2/bar, as part of the root, while leaving the repository URL unchanged.
+// This suits modules whose major versions are kept on branches at the root of the repository, such
+// as a module example.com/foo/v2 declared in the go.mod at the root of its repository's v2 branch.
+// It should not be used with modules whose major versions are kept in subdirectories, such as a
+// v2 directory in the repository root.
+//
+// The -gopkg-versions option serves gopkg.in-style import paths under a wildcard, whose wildcard
+// element ends in a major version such as .v2. The version is kept in the import root but removed
+// from the element substituted in the repo URL, and is given to templates and go-source URL
+// templates as the ref. For example, with rsc.io/* and https://github.com/rsc/*, rsc.io/pkg.v2/sub
+// has the import root rsc.io/pkg.v2, the repository https://github.com/rsc/pkg, and the ref v2. An
+// element merely containing a dot, such as pkg.util, is unaffected. Unlike gopkg.in, the go-import
+// meta tag can't select a branch, so the repository must serve the version itself, such as with a
+// go.mod declaring the module rsc.io/pkg.v2.
+//
+// The -robots option serves a /robots.txt on every host disallowing all crawling, so that search
+// engines index the repositories and documentation rather than the redirect pages. The
+// -robots-file option serves the contents of a file as /robots.txt instead. Either takes
+// precedence over wildcard and other import paths covering /robots.txt, unless an import path is
+// exactly host/robots.txt.
+//
+// The -index option serves a page listing every import path and its repository in response to a
+// request for / on a host, unless an import path covers the root of that host.
+//
+// The -v option enables debug logging. This includes, for each request under a wildcard import
+// path, the wildcard element taken from the request and the resulting repository root and suffix.
+//
+// The -quiet option limits logging to errors and warnings, leaving out messages about signals
+// received and configs reloaded. It may not be combined with -v or -log-format.
+//
+// The -debug option serves a status page at /debug/status on any host, listing the listen
+// addresses, whether TLS is used, the number of redirects, the grace period and timeouts, and the
+// uptime, as JSON if the request accepts application/json or as plain text otherwise. It is off by
+// default, since the page describes the server to anyone who can reach it. Like metrics, the page
+// takes precedence over wildcard and other import paths covering it, but it is an error for an
+// import path to be exactly a host followed by /debug/status.
+//
+// The -version option prints the version, commit, and build date of go-import-redirector and exits.
+//
+// The -check option loads and checks the redirects, templates, and other options as usual, then
+// prints the redirects and exits without listening, such as to validate a config file in CI. Any
+// error is reported and exits with status 1.
 //
 package main
 
 import (
-	"bytes"
 	"context"
+	"crypto/tls"
 	"errors"
 	"flag"
 	"fmt"
-	"html/template"
+	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
-	"path"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
 	"time"
 
+	"go.spiff.io/go-import-redirector/redirector"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"golang.org/x/sync/errgroup"
 	"golang.org/x/sys/unix"
 )
 
 var (
-	listenAddr  = flag.String("listen", ":9001", "serve http on `address`")
-	defaultVCS  = flag.String("vcs", "git", "set default version control `system`")
-	gracePeriod = flag.Duration("grace", time.Second*5, "grace `period` for HTTP shutdowns")
+	listenAddr    = flag.String("listen", ":9001", "serve http on `address`es, separated by commas")
+	defaultVCS    = flag.String("vcs", "git", "set default version control `system`")
+	gracePeriod   = flag.Duration("grace", time.Second*5, "grace `period` for HTTP shutdowns")
+	readTimeout   = flag.Duration("read-timeout", time.Second*10, "allow `period` to read each request (0 for no limit)")
+	writeTimeout  = flag.Duration("write-timeout", time.Second*10, "allow `period` to write each response (0 for no limit)")
+	idleTimeout   = flag.Duration("idle-timeout", time.Minute*2, "close idle keep-alive connections after `period` (0 for no limit)")
+	rootAction    = flag.String("wildcard-root", "docs", "respond to bare wildcard roots with `action` (docs, 404, 204, or a URL)")
+	rootDocs      = flag.String("root-docs", "pkg", "redirect browsers at an import root to `target` docs (pkg or repo)")
+	strictQuery   = flag.Bool("strict-query", false, "reject requests with query parameters other than go-get=1")
+	configFile    = flag.String("config", "", "read import paths and repos from the YAML `file`")
+	readStdin     = flag.Bool("stdin", false, "read import and repo pairs from standard input")
+	tlsCert       = flag.String("tls-cert", "", "serve https using the certificate in `file`")
+	tlsKey        = flag.String("tls-key", "", "serve https using the private key in `file`")
+	autocertHosts = flag.String("autocert-hosts", "", "serve https using Let's Encrypt certificates for comma-separated `hosts`")
+	h2cMode       = flag.Bool("h2c", false, "serve HTTP/2 without TLS (h2c)")
+	autocertCache = flag.String("autocert-cache", "autocert-cache", "store Let's Encrypt certificates in `dir`")
+	docsBase      = flag.String("docs", "https://pkg.go.dev/", "redirect to documentation at base `URL` (empty to disable)")
+	godocLegacy   = flag.Bool("godoc-legacy", false, "redirect to documentation on godoc.org instead of pkg.go.dev")
+	docsFormat    = flag.String("docs-template", "{{.DocsBase}}{{.ImportRoot}}{{.Suffix}}", "build documentation URLs from `template`")
+	maxPathLen    = flag.Int("max-path-length", 1024, "reject request paths longer than `bytes`")
+	majorRoots    = flag.Bool("major-roots", false, "include major version elements such as /v2 in import roots")
+	gopkgVersions = flag.Bool("gopkg-versions", false, "serve wildcard elements such as pkg.v2 from repo pkg at ref v2")
+	index         = flag.Bool("index", false, "serve a page listing all import paths at /")
+	showVersion   = flag.Bool("version", false, "print version information and exit")
+	checkOnly     = flag.Bool("check", false, "check and print the redirects, then exit without serving")
+	verbose       = flag.Bool("v", false, "enable debug logging")
+	requestIDs    = flag.Bool("request-id", false, "generate a UUID X-Request-ID for requests without one")
+	debugStatus   = flag.Bool("debug", false, "serve a status page at /debug/status")
+	quiet         = flag.Bool("quiet", false, "only log errors and warnings")
+	strictMethods = flag.Bool("strict-methods", false, "reject methods other than GET and HEAD")
+	pageTemplate  = flag.String("template", "", "serve all requests using the template in `file`")
+	goGetPage     = flag.String("goget-template", "", "serve go get requests using the template in `file`")
+	notFoundPage  = flag.String("notfound-template", "", "serve 404 responses using the template in `file`")
+	browserPage   = flag.String("browser-template", "", "serve browser requests using the template in `file`")
+	reusePort     = flag.Bool("reuseport", false, "set SO_REUSEPORT on the listening socket")
+	canonical     = flag.Bool("canonical", false, "link to documentation as the canonical page URL")
+	maxRules      = flag.Int("max-rules", 10000, "allow at most `n` import and repo pairs")
+	wildcardDepth = flag.Int("wildcard-depth", 1, "substitute `n` path elements for each /* wildcard (/** takes the full path)")
+	drainMode     = flag.String("drain-mode", "close", "handle the listener during shutdown using `mode` (close or serve)")
+	shutdownDrain = flag.Bool("shutdown-drain", false, "fail health checks with a 503 once shutting down")
+	strictHosts   = flag.Bool("strict", false, "reject import paths whose repo is on the same host")
+	verifyMode    = flag.String("verify-repos", "", "check that repos exist on startup and `warn` or fail if not")
+	logOutput     = flag.String("log-output", "stderr", "write logs to `dest` (stderr, stdout, syslog, or a file)")
+	logFormat     = flag.String("log-format", "", "log each request in `format` (text or json)")
+	jsonLD        = flag.Bool("jsonld", false, "describe packages for search engines using JSON-LD")
+	minify        = flag.Bool("minify", false, "remove whitespace between tags in the head of rendered pages")
+	minimal       = flag.Bool("minimal", false, "serve go get requests only the go-import and go-source tags")
+	manualRedir   = flag.Bool("manual-redirect", false, "link to documentation without automatically redirecting")
+	cacheMaxAge   = flag.Duration("cache-max-age", 0, "allow clients to cache responses for `period` (0 to disable)")
+	hstsMaxAge    = flag.Duration("hsts", 0, "send Strict-Transport-Security with a max-age of `period` (0 to disable)")
+	hstsSubdomain = flag.Bool("hsts-subdomains", false, "include subdomains in the -hsts policy")
+	robots        = flag.Bool("robots", false, "serve a /robots.txt disallowing all crawling")
+	robotsFile    = flag.String("robots-file", "", "serve /robots.txt from `file`")
+	httpsRedirect = flag.Bool("https-redirect", false, "redirect browser requests over http to https")
+	permanent     = flag.Bool("permanent", false, "use 301 instead of 302 for direct redirects")
+	strictGoGet   = flag.Bool("strict-goget", false, "redirect requests without go-get=1 instead of serving the page")
+	maxInflight   = flag.Int("max-inflight", 0, "handle at most `n` requests at once (0 for no limit)")
+	inflightWait  = flag.Duration("inflight-wait", 100*time.Millisecond, "wait up to `period` for a request slot under -max-inflight")
+	trustForward  = flag.Bool("trust-forwarded", false, "trust forwarded headers from all clients")
+	healthPath    = flag.String("health-path", "/healthz", "answer health checks at `path` (empty to disable)")
+	metricsPath   = flag.String("metrics-path", "", "serve Prometheus metrics at `path`")
+	agentAllow    = flag.String("useragent-allow", "", "only serve requests whose User-Agent matches `regexp`")
+
+	vcsAliases   = aliasFlag{}
+	allowedHosts listFlag
+	verifySkip   listFlag
+	trustedCIDRs listFlag
 )
 
+func init() {
+	flag.Var(vcsAliases, "vcs-alias", "map version control system `from=to` (may be repeated)")
+	flag.Var(&allowedHosts, "allowed-host", "only serve requests for `host` (may be repeated)")
+	flag.Var(&verifySkip, "verify-skip", "don't verify the repo for `import` path (may be repeated)")
+	flag.Var(&trustedCIDRs, "trusted-cidr", "trust forwarded headers from proxies in `cidr` (may be repeated)")
+}
+
+// aliasFlag is a repeatable flag of from=to pairs.
+type aliasFlag map[string]string
+
+func (a aliasFlag) String() string {
+	pairs := make([]string, 0, len(a))
+	for from, to := range a {
+		pairs = append(pairs, from+"="+to)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (a aliasFlag) Set(value string) error {
+	sep := strings.IndexByte(value, '=')
+	if sep <= 0 || sep == len(value)-1 {
+		return errors.New("alias must be of the form from=to")
+	}
+	a[value[:sep]] = value[sep+1:]
+	return nil
+}
+
+// listFlag is a repeatable string flag.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 func usage() {
-	fmt.Fprintln(os.Stderr, "Usage: go-import-redirector [options] <import> <repo> ...\n")
+	fmt.Fprint(os.Stderr, "Usage: go-import-redirector [options] <import> <repo> ...\n")
+	fmt.Fprint(os.Stderr, "       go-import-redirector [options] -config <file>\n")
+	fmt.Fprint(os.Stderr, "       go-import-redirector [options] -stdin [<import> <repo> ...]\n\n")
 	fmt.Fprintln(os.Stderr, "options:")
 	flag.PrintDefaults()
 	fmt.Fprintln(os.Stderr, "examples:")
@@ -89,186 +608,874 @@ func main() {
 	flag.Usage = usage
 	flag.Parse()
 
+	if *showVersion {
+		fmt.Println(versionString())
+		return
+	}
+
+	if err := setLogOutput(*logOutput); err != nil {
+		log.Fatalf("error opening log output %s: %v", *logOutput, err)
+	}
+
 	narg := flag.NArg()
-	if narg < 2 || narg%2 != 0 {
+	if *configFile != "" {
+		if narg != 0 || *readStdin {
+			log.Fatalf("import and repo pairs may not be given with -config")
+		}
+	} else if (narg < 2 && !*readStdin) || narg%2 != 0 {
 		flag.Usage()
 	}
 
-	mux := http.NewServeMux()
-	for i := 0; i < narg; i += 2 {
-		importPath := flag.Arg(i)
-		repoPath := flag.Arg(i + 1)
-		redirect, err := newRedirect(importPath, repoPath)
+	switch action := *rootAction; action {
+	case "docs", "404", "204":
+	default:
+		if !strings.Contains(action, "://") {
+			log.Fatalf("invalid -wildcard-root %q: must be docs, 404, 204, or a full URL", action)
+		}
+	}
+
+	if *wildcardDepth < 1 {
+		log.Fatalf("invalid -wildcard-depth %d: must be at least 1", *wildcardDepth)
+	}
+
+	if (*tlsCert == "") != (*tlsKey == "") {
+		log.Fatalf("-tls-cert and -tls-key must be given together")
+	}
+	cert, err := loadCertificate()
+	if err != nil {
+		log.Fatalf("error loading TLS certificate: %v", err)
+	}
+
+	if port := os.Getenv("PORT"); port != "" && !isFlagSet("listen") {
+		*listenAddr = ":" + port
+	}
+
+	var manager *autocert.Manager
+	if hosts := splitList(*autocertHosts); len(hosts) > 0 {
+		if cert != nil {
+			log.Fatalf("-autocert-hosts may not be combined with -tls-cert and -tls-key or TLS_CERT_PEM")
+		}
+		manager = newAutocertManager(hosts, *autocertCache)
+		if !isFlagSet("listen") && os.Getenv("PORT") == "" {
+			*listenAddr = ":443"
+		}
+	}
+
+	if *h2cMode && (cert != nil || manager != nil) {
+		log.Fatalf("-h2c may not be combined with TLS")
+	}
+
+	if *drainMode != "close" && *drainMode != "serve" {
+		log.Fatalf("invalid -drain-mode %q: must be close or serve", *drainMode)
+	}
+
+	switch *verifyMode {
+	case "", "warn", "fail":
+	default:
+		log.Fatalf("invalid -verify-repos %q: must be warn or fail", *verifyMode)
+	}
+
+	switch *logFormat {
+	case "", "text", "json":
+	default:
+		log.Fatalf("invalid -log-format %q: must be text or json", *logFormat)
+	}
+	if *quiet && (*verbose || *logFormat != "") {
+		log.Fatalf("-quiet may not be combined with -v or -log-format")
+	}
+
+	trustedNets, err := parseCIDRs(trustedCIDRs)
+	if err != nil {
+		log.Fatalf("invalid -trusted-cidr: %v", err)
+	}
+	if *trustForward && len(trustedNets) > 0 {
+		log.Fatalf("-trust-forwarded may not be combined with -trusted-cidr")
+	}
+
+	if *hstsMaxAge < 0 {
+		log.Fatalf("invalid -hsts %v: must not be negative", *hstsMaxAge)
+	}
+	if *hstsSubdomain && *hstsMaxAge == 0 {
+		log.Fatalf("-hsts-subdomains may only be used with -hsts")
+	}
+
+	if *rootDocs != "pkg" && *rootDocs != "repo" {
+		log.Fatalf("invalid -root-docs %q: must be pkg or repo", *rootDocs)
+	}
+
+	if *godocLegacy {
+		if isFlagSet("docs") {
+			log.Fatalf("-godoc-legacy may not be combined with -docs")
+		}
+		*docsBase = "https://godoc.org/"
+	}
+	if *docsBase != "" {
+		if *docsBase, err = redirector.NormalizeDocsBase(*docsBase); err != nil {
+			log.Fatalf("invalid -docs: %v", err)
+		}
+	}
+
+	robotsPolicy := ""
+	if *robots {
+		robotsPolicy = "User-agent: *\nDisallow: /\n"
+	}
+	if *robotsFile != "" {
+		b, err := ioutil.ReadFile(*robotsFile)
 		if err != nil {
-			log.Fatalf("error creating redirect %s -> %s: %v", err)
+			log.Fatalf("error reading -robots-file: %v", err)
 		}
-		mux.Handle(redirect.root(), redirect)
+		robotsPolicy = string(b)
 	}
 
-	network, addr := "tcp", *listenAddr
-	if strings.HasPrefix(addr, "unix:") {
-		network, addr = "unix", addr[5:]
+	opts := &redirector.Options{
+		DefaultVCS:     *defaultVCS,
+		VCSAliases:     vcsAliases,
+		DocsBase:       *docsBase,
+		RootDocs:       *rootDocs,
+		WildcardRoot:   *rootAction,
+		WildcardDepth:  *wildcardDepth,
+		MajorRoots:     *majorRoots,
+		GopkgVersions:  *gopkgVersions,
+		MaxPathLen:     *maxPathLen,
+		StrictMethods:  *strictMethods,
+		StrictQuery:    *strictQuery,
+		StrictGoGet:    *strictGoGet,
+		Permanent:      *permanent,
+		CacheMaxAge:    *cacheMaxAge,
+		ManualRedirect: *manualRedir,
+		Canonical:      *canonical,
+		JSONLD:         *jsonLD,
+		Minify:         *minify,
+		HealthPath:     *healthPath,
+		Index:          *index,
+		Robots:         robotsPolicy,
+		Debugf:         debugRequestf,
 	}
 
-	listener, err := net.Listen(network, addr)
+	// draining is set once a signal to shut down is received under -shutdown-drain.
+	var draining int32
+	if *shutdownDrain {
+		opts.HealthCheck = func() error {
+			if atomic.LoadInt32(&draining) != 0 {
+				return errors.New("shutting down")
+			}
+			return nil
+		}
+	}
+
+	if opts.DocsTemplate, err = redirector.ParseDocsTemplate(*docsFormat); err != nil {
+		log.Fatalf("invalid -docs-template: %v", err)
+	}
+
+	if err := loadTemplates(opts, *pageTemplate, *goGetPage, *browserPage); err != nil {
+		log.Fatalf("error loading templates: %v", err)
+	}
+	if *minimal {
+		if *goGetPage != "" {
+			log.Fatalf("-minimal may not be combined with -goget-template")
+		}
+		opts.GoGetTemplate = redirector.MinimalTemplate
+	}
+	if *notFoundPage != "" {
+		if opts.NotFoundTemplate, err = redirector.ParseNotFoundTemplate(*notFoundPage); err != nil {
+			log.Fatalf("error loading -notfound-template: %v", err)
+		}
+	}
+
+	var redirects []*redirector.Redirect
+	if *configFile != "" {
+		if redirects, err = loadConfig(*configFile, opts); err != nil {
+			log.Fatalf("error loading config: %v", err)
+		}
+	} else {
+		for i := 0; i < narg; i += 2 {
+			importPath := flag.Arg(i)
+			repoPath := flag.Arg(i + 1)
+			redirect, err := redirector.NewRedirect(redirector.Entry{ImportPath: importPath, Repo: repoPath}, opts)
+			if err != nil {
+				log.Fatalf("error creating redirect %s -> %s: %v", importPath, repoPath, err)
+			}
+			redirects = append(redirects, redirect)
+		}
+		if *readStdin {
+			pairs, err := readPairs(os.Stdin, "stdin", opts)
+			if err != nil {
+				log.Fatalf("error reading redirects: %v", err)
+			}
+			redirects = append(redirects, pairs...)
+		}
+	}
+	if *healthPath != "" && !strings.HasPrefix(*healthPath, "/") {
+		log.Fatalf("-health-path %q must begin with a /", *healthPath)
+	}
+	if *shutdownDrain && *healthPath == "" {
+		log.Fatalf("-shutdown-drain requires -health-path")
+	}
+	if *metricsPath != "" && !strings.HasPrefix(*metricsPath, "/") {
+		log.Fatalf("-metrics-path %q must begin with a /", *metricsPath)
+	}
+	var allowedAgents *regexp.Regexp
+	if *agentAllow != "" {
+		allowedAgents, err = regexp.Compile(*agentAllow)
+		if err != nil {
+			log.Fatalf("invalid -useragent-allow: %v", err)
+		}
+	}
+
+	routes := new(routerSwitch)
+	rt, err := buildRouter(redirects, opts)
 	if err != nil {
-		log.Fatalf("error creating listener")
+		log.Fatal(err)
+	}
+	routes.store(rt)
+
+	if *checkOnly {
+		printRedirects(os.Stdout, redirects)
+		return
+	}
+
+	// A failure while serving or shutting down exits with status 1 once the listeners below have
+	// been closed and any socket files removed.
+	failed := false
+	defer func() {
+		if failed {
+			os.Exit(1)
+		}
+	}()
+
+	listener, err := systemdListener()
+	if err != nil {
+		log.Fatalf("error using systemd socket: %v", err)
+	}
+	listeners := []net.Listener{listener}
+	if listener == nil {
+		addrs := splitList(*listenAddr)
+		if len(addrs) == 0 {
+			log.Fatal("-listen must name at least one address")
+		}
+		listeners = listeners[:0]
+		for _, addr := range addrs {
+			listener, err := listen(addr)
+			if err != nil {
+				// Returning closes the listeners already created.
+				log.Printf("error creating listener for %s: %v", addr, err)
+				failed = true
+				return
+			}
+			if path := socketPath(addr); path != "" {
+				defer os.Remove(path)
+			}
+			defer listener.Close()
+			listeners = append(listeners, listener)
+		}
+	} else {
+		defer listener.Close()
+	}
+
+	var handler http.Handler = routes
+	if *maxInflight > 0 {
+		handler = limitInflight(handler, *maxInflight, *inflightWait, *healthPath)
+	}
+	if len(allowedHosts) > 0 {
+		handler = allowHosts(handler, allowedHosts, *healthPath)
+	}
+	if allowedAgents != nil {
+		handler = allowUserAgents(handler, allowedAgents, *healthPath)
+	}
+	if *metricsPath != "" {
+		handler = metrics(handler, *metricsPath)
+	}
+	if *debugStatus {
+		tlsMode := "off"
+		switch {
+		case manager != nil:
+			tlsMode = "autocert"
+		case cert != nil:
+			tlsMode = "certificate"
+		}
+		handler = statusPage(handler, routes, listeners, tlsMode)
+	}
+	if *httpsRedirect {
+		handler = redirectHTTPS(handler, *healthPath, *metricsPath)
+	}
+	if *hstsMaxAge > 0 {
+		handler = hsts(handler, *hstsMaxAge, *hstsSubdomain)
+	}
+	if *logFormat != "" {
+		handler = accessLog(handler, *logFormat)
+	}
+	handler = withRequestID(handler, *requestIDs)
+	if len(trustedNets) > 0 || *trustForward {
+		handler = forwarded(handler, trustedNets, *trustForward)
 	}
-	defer listener.Close()
 
-	server := &http.Server{
-		Handler: mux,
+	server := newServer(handler)
+	servers := []*http.Server{server}
+	if cert != nil {
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{*cert}}
+	}
+
+	var challengeListener net.Listener
+	if manager != nil {
+		server.TLSConfig = manager.TLSConfig()
+		challengeListener, err = listen(":80")
+		if err != nil {
+			log.Printf("error creating ACME challenge listener: %v", err)
+			failed = true
+			return
+		}
+		defer challengeListener.Close()
+		servers = append(servers, newServer(manager.HTTPHandler(handler)))
 	}
 
+	// Setting up HTTP/2, here or when serving, may fill in TLSConfig, so whether to use TLS is
+	// decided first.
+	useTLS := server.TLSConfig != nil
+	if *h2cMode {
+		// Configuring the server ties h2c connections, which are hijacked from it, to its shutdown.
+		h2s := new(http2.Server)
+		if err := http2.ConfigureServer(server, h2s); err != nil {
+			log.Printf("error configuring h2c: %v", err)
+			failed = true
+			return
+		}
+		server.Handler = h2c.NewHandler(handler, h2s)
+	}
+
+	// Errors are logged where they happen, identifying the listener or step that failed, so that
+	// wg only needs to report whether any did.
 	var wg errgroup.Group
 	defer func() {
 		if err := wg.Wait(); err != nil {
-			log.Panicf("fatal error: %v", err)
+			failed = true
 		}
 	}()
 
+	// A listener that fails stops the others, so that the process exits rather than serving on only
+	// some of its addresses.
+	stopped := make(chan struct{})
+	var stopOnce sync.Once
+	stopServing := func() {
+		stopOnce.Do(func() {
+			close(stopped)
+			for _, server := range servers {
+				server.Close()
+			}
+		})
+	}
+
+	// Signals are caught before any listener is served, so that one arriving just after startup
+	// isn't left to its default action of killing the process.
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, unix.SIGTERM, unix.SIGHUP)
 	wg.Go(func() error {
-		sig := make(chan os.Signal, 1)
-		signal.Notify(sig, os.Interrupt, unix.SIGTERM, unix.SIGHUP)
 		defer signal.Stop(sig)
 
-		note := <-sig
-		log.Printf("received signal %v; shutting down", note)
+		var note os.Signal
+		for {
+			select {
+			case note = <-sig:
+			case <-stopped:
+				return nil
+			}
+			if note != unix.SIGHUP {
+				break
+			}
+			if err := reopenLog(); err != nil {
+				log.Printf("error reopening log output: %v", err)
+			}
+			if *configFile != "" {
+				reloadConfig(routes, opts)
+			}
+		}
+		infof("received signal %v; shutting down", note)
+		atomic.StoreInt32(&draining, 1)
 
-		period := *gracePeriod
+		period := shutdownGrace(note)
 		if period <= 0 {
-			return server.Close()
+			for _, server := range servers {
+				if err := server.Close(); err != nil {
+					log.Printf("error closing server: %v", err)
+					return err
+				}
+			}
+			return nil
 		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), *gracePeriod)
-		defer cancel()
-		err := server.Shutdown(ctx)
-		if err != nil {
-			log.Printf("")
-			return err
+		if *drainMode == "serve" {
+			for _, server := range servers {
+				server.SetKeepAlivesEnabled(false)
+			}
+			time.Sleep(period)
 		}
-		return nil
-	})
 
-	wg.Go(func() error {
-		err := server.Serve(listener)
-		if err != nil && err != http.ErrServerClosed {
-			return err
+		ctx, cancel := context.WithTimeout(context.Background(), period)
+		defer cancel()
+		var shutdownErr error
+		for _, server := range servers {
+			if err := server.Shutdown(ctx); err != nil {
+				// Requests still running after the grace period are cut off.
+				log.Printf("error shutting down gracefully, closing remaining connections: %v", err)
+				server.Close()
+				shutdownErr = err
+			}
 		}
-		return nil
+		return shutdownErr
 	})
+
+	for _, listener := range listeners {
+		listener := listener
+		wg.Go(func() error {
+			var err error
+			if useTLS {
+				err = server.ServeTLS(listener, "", "")
+			} else {
+				err = server.Serve(listener)
+			}
+			if err != nil && err != http.ErrServerClosed {
+				log.Printf("error serving on %s: %v", listener.Addr(), err)
+				stopServing()
+				return err
+			}
+			return nil
+		})
+	}
+
+	if challengeListener != nil {
+		wg.Go(func() error {
+			err := servers[1].Serve(challengeListener)
+			if err != nil && err != http.ErrServerClosed {
+				log.Printf("error serving ACME challenges on %s: %v", challengeListener.Addr(), err)
+				stopServing()
+				return err
+			}
+			return nil
+		})
+	}
 }
 
-var tmpl = template.Must(template.New("main").Parse(`<!DOCTYPE html>
-<html>
-<head>
-<meta http-equiv="Content-Type" content="text/html; charset=utf-8"/>
-<meta name="go-import" content="{{.ImportRoot}} {{.VCS}} {{.VCSRoot}}">
-<meta http-equiv="refresh" content="0; url=https://godoc.org/{{.ImportRoot}}{{.Suffix}}">
-</head>
-<body>
-Redirecting to docs at <a href="https://godoc.org/{{.ImportRoot}}{{.Suffix}}">godoc.org/{{.ImportRoot}}{{.Suffix}}</a>...
-</body>
-</html>
-`))
+// loadCertificate loads the TLS certificate given by -tls-cert and -tls-key or, failing those, by
+// the TLS_CERT_PEM and TLS_KEY_PEM environment variables. It returns nil if neither is set.
+func loadCertificate() (*tls.Certificate, error) {
+	if *tlsCert != "" {
+		cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+		if err != nil {
+			return nil, err
+		}
+		return &cert, nil
+	}
 
-type data struct {
-	ImportRoot string
-	VCS        string
-	VCSRoot    string
-	Suffix     string
+	certPEM, keyPEM := os.Getenv("TLS_CERT_PEM"), os.Getenv("TLS_KEY_PEM")
+	if certPEM == "" && keyPEM == "" {
+		return nil, nil
+	}
+	if certPEM == "" || keyPEM == "" {
+		return nil, errors.New("TLS_CERT_PEM and TLS_KEY_PEM must be set together")
+	}
+	cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
 }
 
-type redirectPath struct {
-	wildcard   bool
-	importPath string
-	repo       *url.URL
-	vcs        string
+// newServer returns a server for handler with the timeouts set by -read-timeout, -write-timeout,
+// and -idle-timeout.
+func newServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadTimeout:       *readTimeout,
+		ReadHeaderTimeout: *readTimeout,
+		WriteTimeout:      *writeTimeout,
+		IdleTimeout:       *idleTimeout,
+	}
 }
 
-func newRedirect(importPath, repoPath string) (*redirectPath, error) {
-	if !strings.Contains(repoPath, "://") {
-		return nil, errors.New("repo path must be full URL")
+// listen returns a listener for addr, which is a TCP address or, if it begins with "unix:", the
+// path of a Unix domain socket.
+func listen(addr string) (net.Listener, error) {
+	network := "tcp"
+	if strings.HasPrefix(addr, "unix:") {
+		network, addr = "unix", addr[5:]
+		if strings.HasPrefix(addr, "@") && !abstractSockets {
+			return nil, errors.New("abstract unix sockets are not supported on this platform")
+		}
+		if !strings.HasPrefix(addr, "@") {
+			removeStaleSocket(addr)
+		}
 	}
-	wildcard := strings.HasSuffix(importPath, "/*")
-	if wildcard != strings.HasSuffix(repoPath, "/*") {
-		return nil, errors.New("either both import and repo must have /* or neither")
+
+	var lc net.ListenConfig
+	if *reusePort {
+		if reusePortControl == nil {
+			return nil, errors.New("-reuseport is not supported on this platform")
+		}
+		lc.Control = reusePortControl
 	}
-	if wildcard {
-		importPath = strings.TrimSuffix(importPath, "/*")
-		repoPath = strings.TrimSuffix(repoPath, "/*")
+	return lc.Listen(context.Background(), network, addr)
+}
+
+// socketPath returns the file path of the Unix domain socket named by addr, or the empty string if
+// addr is a TCP address or names an abstract socket.
+func socketPath(addr string) string {
+	if !strings.HasPrefix(addr, "unix:") || strings.HasPrefix(addr, "unix:@") {
+		return ""
 	}
+	return addr[5:]
+}
 
-	importPath = strings.TrimSuffix(importPath, "/")
-	repo, err := url.Parse(repoPath)
-	if err != nil {
-		return nil, err
+// removeStaleSocket removes the socket file at path if nothing is accepting connections on it.
+// Files that are not sockets are left alone so that binding fails with a useful error.
+func removeStaleSocket(path string) {
+	fi, err := os.Lstat(path)
+	if err != nil || fi.Mode()&os.ModeSocket == 0 {
+		return
 	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return
+	}
+	debugf("removing stale socket %s", path)
+	os.Remove(path)
+}
+
+// newAutocertManager returns a manager obtaining certificates from Let's Encrypt for hosts only,
+// stored in the directory cache unless it is empty.
+func newAutocertManager(hosts []string, cache string) *autocert.Manager {
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(hosts...),
+	}
+	if cache != "" {
+		m.Cache = autocert.DirCache(cache)
+	}
+	return m
+}
+
+// isFlagSet returns whether the flag with the given name was set on the command line.
+func isFlagSet(name string) (set bool) {
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
 
-	vcs := *defaultVCS
-	if sep := strings.IndexByte(repo.Scheme, '+'); sep != -1 {
-		vcs, repo.Scheme = repo.Scheme[:sep], repo.Scheme[sep+1:]
+// splitList splits a comma-separated list, omitting empty elements.
+func splitList(list string) []string {
+	var elems []string
+	for _, elem := range strings.Split(list, ",") {
+		if elem = strings.TrimSpace(elem); elem != "" {
+			elems = append(elems, elem)
+		}
 	}
+	return elems
+}
 
-	r := &redirectPath{
-		wildcard:   wildcard,
-		importPath: importPath,
-		repo:       repo,
-		vcs:        vcs,
+// exemptPath returns whether req is for one of the non-empty paths in exempt.
+func exemptPath(req *http.Request, exempt []string) bool {
+	for _, p := range exempt {
+		if p != "" && req.URL.Path == p {
+			return true
+		}
 	}
-	return r, nil
+	return false
 }
 
-func (r *redirectPath) root() string {
-	return r.importPath + "/"
+// allowHosts returns a handler that rejects requests for hosts not in hosts with a 421 Misdirected
+// Request, except for requests for the given paths, and passes all other requests to next.
+func allowHosts(next http.Handler, hosts []string, exempt ...string) http.Handler {
+	allowed := make(map[string]bool, len(hosts))
+	for _, host := range hosts {
+		allowed[strings.ToLower(host)] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !allowed[strings.ToLower(redirector.Hostname(req.Host))] && !exemptPath(req, exempt) {
+			http.Error(w, http.StatusText(http.StatusMisdirectedRequest), http.StatusMisdirectedRequest)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
 }
 
-func (r *redirectPath) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	reqPath := strings.TrimSuffix(req.Host+req.URL.Path, "/")
-	var importRoot, repoRoot, suffix string
-	if r.wildcard {
-		if reqPath == r.importPath {
-			http.Redirect(w, req, "https://godoc.org/"+r.importPath, http.StatusFound)
+// allowUserAgents returns a handler that rejects requests whose User-Agent doesn't match re with a
+// 403 Forbidden, except for requests for the given paths, and passes all other requests to next.
+func allowUserAgents(next http.Handler, re *regexp.Regexp, exempt ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !re.MatchString(req.UserAgent()) && !exemptPath(req, exempt) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
 			return
 		}
-		if !strings.HasPrefix(reqPath, r.root()) {
-			http.NotFound(w, req)
+		next.ServeHTTP(w, req)
+	})
+}
+
+// limitInflight returns a handler that passes at most n concurrent requests to next. When n
+// requests are already in flight, a request waits up to wait for one of them to finish before being
+// rejected with a 503 Service Unavailable. Requests for the given paths are never limited.
+func limitInflight(next http.Handler, n int, wait time.Duration, exempt ...string) http.Handler {
+	sem := make(chan struct{}, n)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if exemptPath(req, exempt) {
+			next.ServeHTTP(w, req)
 			return
 		}
-		elem := reqPath[len(r.importPath)+1:]
-		if i := strings.Index(elem, "/"); i >= 0 {
-			log.Print("chopping")
-			elem, suffix = elem[:i], elem[i:]
+		select {
+		case sem <- struct{}{}:
+		default:
+			timer := time.NewTimer(wait)
+			select {
+			case sem <- struct{}{}:
+				timer.Stop()
+			case <-timer.C:
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+				return
+			case <-req.Context().Done():
+				timer.Stop()
+				return
+			}
 		}
+		defer func() { <-sem }()
+		next.ServeHTTP(w, req)
+	})
+}
 
-		importRoot = path.Join(r.importPath, elem)
-		repo := *r.repo
-		repo.Path = path.Join(repo.Path, elem)
-		repoRoot = repo.String()
-	} else {
-		if reqPath != r.importPath && !strings.HasPrefix(reqPath, r.root()) {
-			http.NotFound(w, req)
+// hsts returns a handler that adds a Strict-Transport-Security header with the given max-age to
+// every response from next.
+func hsts(next http.Handler, maxAge time.Duration, subdomains bool) http.Handler {
+	policy := "max-age=" + strconv.FormatInt(int64(maxAge/time.Second), 10)
+	if subdomains {
+		policy += "; includeSubDomains"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Strict-Transport-Security", policy)
+		next.ServeHTTP(w, req)
+	})
+}
+
+// redirectHTTPS returns a handler that redirects requests made over plain HTTP to HTTPS, except
+// for go get requests and requests for the given paths, which are passed to next along with all
+// HTTPS requests.
+func redirectHTTPS(next http.Handler, exempt ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if requestScheme(req) != "http" || req.URL.Query().Get("go-get") == "1" || exemptPath(req, exempt) {
+			next.ServeHTTP(w, req)
 			return
 		}
-		importRoot = r.importPath
-		repoRoot = r.repo.String()
-		suffix = reqPath[len(r.importPath):]
+
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+			if strings.Contains(host, ":") {
+				host = "[" + host + "]"
+			}
+		}
+		http.Redirect(w, req, "https://"+host+req.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+// requestScheme returns the scheme of req, as forwarded by a trusted proxy or else according to
+// whether it was received over TLS.
+func requestScheme(req *http.Request) string {
+	switch {
+	case forwardedScheme(req) != "":
+		return forwardedScheme(req)
+	case req.TLS != nil:
+		return "https"
+	}
+	return "http"
+}
+
+// buildRouter returns a router for redirects, after checking them against the -max-rules,
+// -health-path, -metrics-path, -debug, -strict, and -verify-repos options.
+func buildRouter(redirects []*redirector.Redirect, opts *redirector.Options) (*redirector.Router, error) {
+	if len(redirects) > *maxRules {
+		return nil, fmt.Errorf("too many redirects: %d exceeds -max-rules %d", len(redirects), *maxRules)
+	}
+
+	for _, redirect := range redirects {
+		if *healthPath != "" && underRoot(*healthPath, redirect) {
+			if isFlagSet("health-path") {
+				return nil, fmt.Errorf("-health-path %q collides with import path %s", *healthPath, redirect.ImportPath())
+			}
+			debugf("health checks at %s are not served on import path %s", *healthPath, redirect.ImportPath())
+		}
+		if *metricsPath != "" && underRoot(*metricsPath, redirect) {
+			// Metrics take precedence over import paths covering them, as /robots.txt does, unless
+			// one is exactly the metrics path and so can't be what was meant.
+			if atPath(*metricsPath, redirect) {
+				return nil, fmt.Errorf("-metrics-path %q collides with import path %s", *metricsPath, redirect.ImportPath())
+			}
+			debugf("metrics at %s take precedence over import path %s", *metricsPath, redirect.ImportPath())
+		}
+		if *debugStatus && underRoot(statusPath, redirect) {
+			// The status page takes precedence in the same way as metrics.
+			if atPath(statusPath, redirect) {
+				return nil, fmt.Errorf("-debug status page %s collides with import path %s", statusPath, redirect.ImportPath())
+			}
+			debugf("status page at %s takes precedence over import path %s", statusPath, redirect.ImportPath())
+		}
+		if sameHost(redirect) {
+			if *strictHosts {
+				return nil, fmt.Errorf("import path %s has repo %s on the same host", redirect.ImportPath(), redirect.Repo())
+			}
+			log.Printf("import path %s has repo %s on the same host, which may loop", redirect.ImportPath(), redirect.Repo())
+		}
 	}
-	d := &data{
-		ImportRoot: importRoot,
-		VCS:        r.vcs,
-		VCSRoot:    repoRoot,
-		Suffix:     suffix,
+
+	if *verifyMode != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), verifyTimeout)
+		missing := verifyRepos(ctx, redirects, verifySkip)
+		cancel()
+		if missing > 0 && *verifyMode == "fail" {
+			return nil, fmt.Errorf("%d repo(s) not found", missing)
+		}
+	}
+
+	return redirector.NewRouter(redirects, opts)
+}
+
+// underRoot returns whether the request path p falls under the root of redirect on its host.
+func underRoot(p string, redirect *redirector.Redirect) bool {
+	root := redirect.ImportPath() + "/"
+	return strings.HasPrefix(p+"/", root[strings.IndexByte(root, '/'):])
+}
+
+// atPath returns whether the import path of redirect is exactly a host followed by the request path
+// p, such as rsc.io/metrics for /metrics.
+func atPath(p string, redirect *redirector.Redirect) bool {
+	importPath := redirect.ImportPath()
+	return importPath[strings.IndexByte(importPath+"/", '/'):] == p
+}
+
+// sameHost returns whether the repo of redirect is on the host of its import path.
+func sameHost(redirect *redirector.Redirect) bool {
+	host := redirect.ImportPath()
+	if i := strings.IndexByte(host, '/'); i >= 0 {
+		host = host[:i]
+	}
+	return strings.EqualFold(redirect.Repo().Hostname(), redirector.Hostname(host))
+}
+
+// reloadConfig reads redirects from the -config file again and swaps them into routes. Requests
+// already being handled finish with the redirects they started with. If the config can't be
+// loaded, the current redirects are kept.
+func reloadConfig(routes *routerSwitch, opts *redirector.Options) {
+	redirects, err := loadConfig(*configFile, opts)
+	var rt *redirector.Router
+	if err == nil {
+		rt, err = buildRouter(redirects, opts)
 	}
-	var buf bytes.Buffer
-	err := tmpl.Execute(&buf, d)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Printf("error reloading config, keeping current redirects: %v", err)
 		return
 	}
-	w.Write(buf.Bytes())
+	routes.store(rt)
+	infof("reloaded %d redirect(s) from %s", len(redirects), *configFile)
+}
+
+// shutdownGrace returns the grace period given to in-flight requests when shutting down on sig. An
+// interrupt (such as Ctrl-C) closes the server immediately, while other signals wait up to the
+// -grace period for requests to finish.
+func shutdownGrace(sig os.Signal) time.Duration {
+	if sig == os.Interrupt {
+		return 0
+	}
+	return *gracePeriod
+}
+
+// printRedirects writes a table of redirects, sorted by import path, to w.
+func printRedirects(w io.Writer, redirects []*redirector.Redirect) {
+	type row struct{ importPath, vcs, repo string }
+	rows := make([]row, 0, len(redirects))
+	for _, r := range redirects {
+		importPath, repo := r.Patterns()
+		rows = append(rows, row{importPath, r.VCS(), repo})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].importPath < rows[j].importPath
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "IMPORT\tVCS\tREPO")
+	for _, r := range rows {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", r.importPath, r.vcs, r.repo)
+	}
+	tw.Flush()
+}
+
+// infof logs a message unless logging is limited to errors by the -quiet flag.
+func infof(format string, args ...interface{}) {
+	if !*quiet {
+		log.Printf(format, args...)
+	}
+}
+
+// debugf logs a message if debug logging is enabled by the -v flag.
+func debugf(format string, args ...interface{}) {
+	if *verbose {
+		log.Printf(format, args...)
+	}
+}
+
+// debugRequestf logs a message about req, prefixed by its ID, if debug logging is enabled.
+func debugRequestf(req *http.Request, format string, args ...interface{}) {
+	if *verbose {
+		log.Printf("[%s] %s", requestID(req), fmt.Sprintf(format, args...))
+	}
+}
+
+// loadTemplates parses the go get and browser template files, if given, and sets the GoGetTemplate
+// and BrowserTemplate of opts. The base file is used for either one not given. Without a base file,
+// if only one file is given, it is used for both.
+func loadTemplates(opts *redirector.Options, baseFile, goGetFile, browserFile string) error {
+	if baseFile != "" {
+		if goGetFile == "" {
+			goGetFile = baseFile
+		}
+		if browserFile == "" {
+			browserFile = baseFile
+		}
+	}
+	if goGetFile == "" {
+		goGetFile = browserFile
+	} else if browserFile == "" {
+		browserFile = goGetFile
+	}
+	if goGetFile == "" {
+		return nil
+	}
+
+	goGet, err := redirector.ParsePageTemplate(goGetFile)
+	if err != nil {
+		return err
+	}
+	browser := goGet
+	if browserFile != goGetFile {
+		if browser, err = redirector.ParsePageTemplate(browserFile); err != nil {
+			return err
+		}
+	}
+	opts.GoGetTemplate, opts.BrowserTemplate = goGet, browser
+	return nil
+}
+
+// routerSwitch is a handler that serves requests with its current router, which may be replaced
+// at any time.
+type routerSwitch struct {
+	v atomic.Value // *redirector.Router
+}
+
+func (s *routerSwitch) store(rt *redirector.Router) {
+	s.v.Store(rt)
+}
+
+func (s *routerSwitch) load() *redirector.Router {
+	return s.v.Load().(*redirector.Router)
 }
 
-func pong(w http.ResponseWriter, req *http.Request) {
-	fmt.Fprintf(w, "pong")
+func (s *routerSwitch) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.load().ServeHTTP(w, req)
 }
@@ -0,0 +1,1190 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"go.spiff.io/go-import-redirector/redirector"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
+)
+
+// mainEnv is set in the environment of the test binary to run main instead of the tests.
+const mainEnv = "GO_IMPORT_REDIRECTOR_TEST_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(mainEnv) == "1" {
+		// The ID of the process isn't known until it starts, so tests faking socket activation
+		// leave it to be filled in here.
+		if os.Getenv("LISTEN_PID") == "self" {
+			os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+		}
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// command returns a command running main with args, and env added to its environment.
+func command(env []string, args ...string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(append(os.Environ(), mainEnv+"=1"), env...)
+	return cmd
+}
+
+// runMain runs main with args until it exits, returning its output.
+func runMain(t *testing.T, env []string, args ...string) (string, error) {
+	t.Helper()
+	out, err := command(env, args...).CombinedOutput()
+	return string(out), err
+}
+
+// server is main running in the background.
+type server struct {
+	cmd *exec.Cmd
+	out bytes.Buffer
+}
+
+// startMain starts main with args and waits until it serves requests on the unix socket sock.
+func startMain(t *testing.T, sock string, env []string, args ...string) *server {
+	t.Helper()
+	return startCommand(t, unixClient(sock), command(env, args...))
+}
+
+// startCommand starts cmd, a command running main, and waits until it serves requests sent with
+// client.
+func startCommand(t *testing.T, client *http.Client, cmd *exec.Cmd) *server {
+	t.Helper()
+	s := &server{cmd: cmd}
+	s.cmd.Stdout, s.cmd.Stderr = &s.out, &s.out
+	if err := s.cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// Any response will do, even the one sent for plain HTTP to a TLS server.
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		resp, err := client.Get("http://localhost/")
+		if err == nil {
+			resp.Body.Close()
+			return s
+		}
+		if time.Since(start) > 5*time.Second {
+			s.cmd.Process.Kill()
+			s.cmd.Wait()
+			t.Fatalf("server did not start serving: %v\n%s", err, s.out.String())
+		}
+	}
+}
+
+// stop sends sig to the server and returns its output once it exits.
+func (s *server) stop(t *testing.T, sig os.Signal) string {
+	t.Helper()
+	if err := s.cmd.Process.Signal(sig); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.cmd.Wait(); err != nil {
+		t.Errorf("server exited with an error: %v\n%s", err, s.out.String())
+	}
+	return s.out.String()
+}
+
+// unixClient returns a client sending all requests to the unix socket sock.
+func unixClient(sock string) *http.Client {
+	return dialClient("unix", sock)
+}
+
+// dialClient returns a client sending all requests to addr on network, whatever their URL, and
+// returning redirects rather than following them.
+func dialClient(network, addr string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// fetch sends a GET request for url with client, returning the response and its body.
+func fetch(t *testing.T, client *http.Client, url string) (*http.Response, string) {
+	t.Helper()
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", url, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("GET %s: error reading body: %v", url, err)
+	}
+	return resp, string(body)
+}
+
+// fetchMain starts main with args on a unix socket, sends a GET request for url to it, and shuts it
+// down, returning the response and its body.
+func fetchMain(t *testing.T, url string, args ...string) (*http.Response, string) {
+	t.Helper()
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	sock := filepath.Join(dir, "redirector.sock")
+	s := startMain(t, sock, nil, append([]string{"-listen=unix:" + sock}, args...)...)
+	defer s.stop(t, syscall.SIGTERM)
+	return fetch(t, unixClient(sock), url)
+}
+
+var (
+	goImportRE = regexp.MustCompile(`<meta name="go-import" content="([^"]*)">`)
+	refreshRE  = regexp.MustCompile(`<meta http-equiv="refresh" content="0; url=([^"]*)">`)
+)
+
+// goImport returns the content of the go-import meta tag in body, or "" if there is none.
+func goImport(body string) string {
+	if m := goImportRE.FindStringSubmatch(body); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// refresh returns the URL of the refresh meta tag in body, or "" if there is none.
+func refresh(body string) string {
+	if m := refreshRE.FindStringSubmatch(body); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// tempDir returns a new temporary directory and a function removing it.
+func tempDir(t *testing.T) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "go-import-redirector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// writeFile writes content to the file name in dir and returns its path.
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	file := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// execute returns the output of t for a sample page, or "" if t is nil.
+func execute(t *template.Template) string {
+	if t == nil {
+		return ""
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, &redirector.Data{}); err != nil {
+		return err.Error()
+	}
+	return buf.String()
+}
+
+func TestLoadTemplates(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	base := writeFile(t, dir, "base.html", `base`)
+	goGet := writeFile(t, dir, "go-get.html", `go-get`)
+	browser := writeFile(t, dir, "browser.html", `browser`)
+
+	tests := []struct {
+		base, goGet, browser   string
+		wantGoGet, wantBrowser string
+	}{
+		{"", "", "", "", ""},
+		{base, "", "", "base", "base"},
+		{"", goGet, browser, "go-get", "browser"},
+		{"", goGet, "", "go-get", "go-get"},
+		{"", "", browser, "browser", "browser"},
+		{base, goGet, "", "go-get", "base"},
+		{base, "", browser, "base", "browser"},
+	}
+	for _, tt := range tests {
+		var opts redirector.Options
+		if err := loadTemplates(&opts, tt.base, tt.goGet, tt.browser); err != nil {
+			t.Errorf("loadTemplates(%q, %q, %q) failed: %v", tt.base, tt.goGet, tt.browser, err)
+			continue
+		}
+		if got := execute(opts.GoGetTemplate); got != tt.wantGoGet {
+			t.Errorf("loadTemplates(%q, %q, %q): go get template = %q, want %q",
+				tt.base, tt.goGet, tt.browser, got, tt.wantGoGet)
+		}
+		if got := execute(opts.BrowserTemplate); got != tt.wantBrowser {
+			t.Errorf("loadTemplates(%q, %q, %q): browser template = %q, want %q",
+				tt.base, tt.goGet, tt.browser, got, tt.wantBrowser)
+		}
+	}
+
+	bad := writeFile(t, dir, "bad.html", `{{.NoSuchField}}`)
+	var opts redirector.Options
+	if err := loadTemplates(&opts, "", bad, ""); err == nil {
+		t.Errorf("loadTemplates(%q) succeeded for a template using an unknown field", bad)
+	}
+}
+
+func TestShutdownGrace(t *testing.T) {
+	defer func(d time.Duration) { *gracePeriod = d }(*gracePeriod)
+	*gracePeriod = 3 * time.Second
+	tests := []struct {
+		sig  os.Signal
+		want time.Duration
+	}{
+		{os.Interrupt, 0},
+		{syscall.SIGTERM, 3 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := shutdownGrace(tt.sig); got != tt.want {
+			t.Errorf("shutdownGrace(%v) = %v, want %v", tt.sig, got, tt.want)
+		}
+	}
+}
+
+// newTestRouter returns a redirector handler for the given import path and repo pairs, answering
+// health checks on /healthz.
+func newTestRouter(t *testing.T, pairs ...string) http.Handler {
+	t.Helper()
+	entries := make([]redirector.Entry, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		entries = append(entries, redirector.Entry{ImportPath: pairs[i], Repo: pairs[i+1]})
+	}
+	h, err := redirector.NewHandler(entries, &redirector.Options{
+		DocsBase:   "https://pkg.go.dev/",
+		HealthPath: "/healthz",
+	})
+	if err != nil {
+		t.Fatalf("NewHandler(%q) failed: %v", pairs, err)
+	}
+	return h
+}
+
+// serve serves a GET request for target, a URL without its scheme, with h.
+func serve(h http.Handler, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://"+target, nil))
+	return w
+}
+
+func TestAllowHosts(t *testing.T) {
+	h := allowHosts(newTestRouter(t, "example.com/pkg", "https://github.com/example/pkg"),
+		[]string{"example.com", "Example.org"}, "/healthz")
+	tests := []struct {
+		target string
+		code   int
+	}{
+		{"example.com/pkg", http.StatusOK},
+		{"EXAMPLE.com:8080/pkg", http.StatusOK},
+		// An allowed host with no redirect for the path is a genuine 404.
+		{"example.com/other", http.StatusNotFound},
+		{"example.org/pkg", http.StatusNotFound},
+		{"misrouted.net/pkg", http.StatusMisdirectedRequest},
+		{"misrouted.net/healthz", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if w := serve(h, tt.target); w.Code != tt.code {
+			t.Errorf("GET %s: status = %d, want %d", tt.target, w.Code, tt.code)
+		}
+	}
+}
+
+func TestAllowUserAgents(t *testing.T) {
+	h := allowUserAgents(newTestRouter(t, "example.com/pkg", "https://github.com/example/pkg"),
+		regexp.MustCompile(`^(Go-http-client/|Mozilla/)`), "/healthz")
+	tests := []struct {
+		agent string
+		path  string
+		code  int
+	}{
+		{"Go-http-client/1.1", "/pkg?go-get=1", http.StatusOK},
+		{"Mozilla/5.0 (X11; Linux x86_64)", "/pkg", http.StatusOK},
+		{"scrapy/2.11", "/pkg?go-get=1", http.StatusForbidden},
+		{"", "/pkg", http.StatusForbidden},
+		{"kube-probe/1.29", "/healthz", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com"+tt.path, nil)
+		req.Header.Set("User-Agent", tt.agent)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != tt.code {
+			t.Errorf("GET %s with User-Agent %q: status = %d, want %d", tt.path, tt.agent, w.Code, tt.code)
+		}
+		if tt.code == http.StatusForbidden && goImport(w.Body.String()) != "" {
+			t.Errorf("GET %s with User-Agent %q: served a go-import tag to a denied agent", tt.path, tt.agent)
+		}
+	}
+
+	out, err := runMain(t, nil, "-useragent-allow=(", "rsc.io/*", "https://github.com/rsc/*")
+	if err == nil || !strings.Contains(out, "invalid -useragent-allow") {
+		t.Errorf("running with an invalid -useragent-allow = %v, %q; want an error", err, out)
+	}
+}
+
+func TestLimitInflight(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h := limitInflight(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/healthz" {
+			started <- struct{}{}
+			<-release
+		}
+	}), 2, 10*time.Millisecond, "/healthz")
+
+	var wg sync.WaitGroup
+	codes := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- serve(h, "example.com/pkg").Code
+		}()
+		<-started
+	}
+
+	// Both slots are taken, so another request is turned away after waiting.
+	if w := serve(h, "example.com/pkg"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET past the limit: status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	// Health checks are never limited.
+	if w := serve(h, "example.com/healthz"); w.Code != http.StatusOK {
+		t.Errorf("GET /healthz past the limit: status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	close(release)
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("GET within the limit: status = %d, want %d", code, http.StatusOK)
+		}
+	}
+	// Once the slots are released, requests are served again.
+	go func() { <-started }()
+	if w := serve(h, "example.com/pkg"); w.Code != http.StatusOK {
+		t.Errorf("GET after release: status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+// generateCert returns a new self-signed certificate and private key for example.com, PEM-encoded.
+func generateCert(t *testing.T) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestLoadCertificate(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	certPEM, keyPEM := generateCert(t)
+	certFile := writeFile(t, dir, "cert.pem", string(certPEM))
+	keyFile := writeFile(t, dir, "key.pem", string(keyPEM))
+
+	defer func(cert, key string) { *tlsCert, *tlsKey = cert, key }(*tlsCert, *tlsKey)
+	*tlsCert, *tlsKey = "", ""
+	if cert, err := loadCertificate(); cert != nil || err != nil {
+		t.Errorf("loadCertificate() without a certificate = %v, %v; want nil, nil", cert, err)
+	}
+	*tlsCert, *tlsKey = certFile, keyFile
+	if cert, err := loadCertificate(); cert == nil || err != nil {
+		t.Errorf("loadCertificate() with -tls-cert and -tls-key = %v, %v; want a certificate", cert, err)
+	}
+	*tlsCert, *tlsKey = keyFile, certFile
+	if _, err := loadCertificate(); err == nil {
+		t.Errorf("loadCertificate() with the certificate and key swapped succeeded, want an error")
+	}
+}
+
+func TestServeTLS(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	certPEM, keyPEM := generateCert(t)
+	certFile := writeFile(t, dir, "cert.pem", string(certPEM))
+	keyFile := writeFile(t, dir, "key.pem", string(keyPEM))
+	sock := filepath.Join(dir, "redirector.sock")
+
+	// Either flag alone is refused at startup.
+	for _, arg := range []string{"-tls-cert=" + certFile, "-tls-key=" + keyFile} {
+		out, err := runMain(t, nil, "-listen=unix:"+sock, arg, "rsc.io/*", "https://github.com/rsc/*")
+		if err == nil || !strings.Contains(out, "-tls-cert and -tls-key must be given together") {
+			t.Errorf("running with only %s = %v, %q; want an error", arg, err, out)
+		}
+	}
+
+	s := startMain(t, sock, nil, "-listen=unix:"+sock, "-tls-cert="+certFile, "-tls-key="+keyFile,
+		"rsc.io/*", "https://github.com/rsc/*")
+	defer s.stop(t, syscall.SIGTERM)
+	resp, body := fetch(t, unixClient(sock), "https://rsc.io/pdf?go-get=1")
+	if resp.TLS == nil {
+		t.Errorf("GET https://rsc.io/pdf was not served over TLS")
+	}
+	if meta, want := goImport(body), "rsc.io/pdf git https://github.com/rsc/pdf"; meta != want {
+		t.Errorf("GET https://rsc.io/pdf: go-import = %q, want %q", meta, want)
+	}
+}
+
+func TestBadRedirectMessage(t *testing.T) {
+	out, err := runMain(t, nil, "rsc.io/pdf", "https://github.com/rsc/pdf", "rsc.io/*", "https://github.com/rsc")
+	if err == nil {
+		t.Fatalf("running with a bad redirect succeeded, want an error")
+	}
+	if want := "error creating redirect rsc.io/* -> https://github.com/rsc:"; !strings.Contains(out, want) {
+		t.Errorf("output %q does not contain %q", out, want)
+	}
+	if strings.Contains(out, "%!") {
+		t.Errorf("output %q has a formatting error", out)
+	}
+}
+
+func TestNewServer(t *testing.T) {
+	defer func(r, w, i time.Duration) {
+		*readTimeout, *writeTimeout, *idleTimeout = r, w, i
+	}(*readTimeout, *writeTimeout, *idleTimeout)
+
+	if *readTimeout <= 0 || *writeTimeout <= 0 || *idleTimeout <= 0 {
+		t.Errorf("default timeouts are %v, %v, %v; want them all set", *readTimeout, *writeTimeout, *idleTimeout)
+	}
+	*readTimeout, *writeTimeout, *idleTimeout = time.Second, 2*time.Second, 3*time.Second
+	h := http.NotFoundHandler()
+	s := newServer(h)
+	if s.ReadTimeout != time.Second || s.ReadHeaderTimeout != time.Second ||
+		s.WriteTimeout != 2*time.Second || s.IdleTimeout != 3*time.Second {
+		t.Errorf("newServer timeouts: read %v, read header %v, write %v, idle %v; want 1s, 1s, 2s, 3s",
+			s.ReadTimeout, s.ReadHeaderTimeout, s.WriteTimeout, s.IdleTimeout)
+	}
+	if s.Handler == nil {
+		t.Errorf("newServer has no handler")
+	}
+}
+
+// freePort returns a TCP port on the loopback address that nothing is listening on.
+func freePort(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	_, port, err := net.SplitHostPort(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return port
+}
+
+func TestPortEnv(t *testing.T) {
+	if def := flag.Lookup("listen").DefValue; def != ":9001" {
+		t.Errorf("-listen defaults to %q, want %q", def, ":9001")
+	}
+
+	// Without -listen, PORT is used.
+	port := freePort(t)
+	client := dialClient("tcp", "127.0.0.1:"+port)
+	s := startCommand(t, client, command([]string{"PORT=" + port}, "rsc.io/*", "https://github.com/rsc/*"))
+	_, body := fetch(t, client, "http://rsc.io/pdf?go-get=1")
+	if meta, want := goImport(body), "rsc.io/pdf git https://github.com/rsc/pdf"; meta != want {
+		t.Errorf("GET rsc.io/pdf on $PORT: go-import = %q, want %q", meta, want)
+	}
+	s.stop(t, syscall.SIGTERM)
+
+	// An explicit -listen wins over PORT.
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	sock := filepath.Join(dir, "redirector.sock")
+	s = startMain(t, sock, []string{"PORT=" + port}, "-listen=unix:"+sock, "rsc.io/*", "https://github.com/rsc/*")
+	defer s.stop(t, syscall.SIGTERM)
+	if conn, err := net.Dial("tcp", "127.0.0.1:"+port); err == nil {
+		conn.Close()
+		t.Errorf("listening on $PORT %s with -listen given", port)
+	}
+}
+
+func TestSocketPath(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"unix:/run/redirector.sock", "/run/redirector.sock"},
+		{"unix:@redirector", ""},
+		{":9001", ""},
+		{"localhost:9001", ""},
+	}
+	for _, tt := range tests {
+		if got := socketPath(tt.addr); got != tt.want {
+			t.Errorf("socketPath(%q) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestSocketRemoved(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	sock := filepath.Join(dir, "redirector.sock")
+
+	// A stale socket left by a process that didn't clean up is replaced.
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+
+	s := startMain(t, sock, nil, "-listen=unix:"+sock, "rsc.io/*", "https://github.com/rsc/*")
+	s.stop(t, syscall.SIGTERM)
+	if _, err := os.Lstat(sock); !os.IsNotExist(err) {
+		t.Errorf("socket %s still exists after shutdown: %v", sock, err)
+	}
+
+	// Anything other than a socket is left alone.
+	writeFile(t, dir, "redirector.sock", "not a socket")
+	out, err := runMain(t, nil, "-listen=unix:"+sock, "rsc.io/*", "https://github.com/rsc/*")
+	if err == nil {
+		t.Errorf("listening on a regular file succeeded:\n%s", out)
+	}
+	if got := readFile(t, sock); got != "not a socket" {
+		t.Errorf("file at the socket path = %q after a failed start, want it unchanged", got)
+	}
+}
+
+func TestMultipleListeners(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	sock := filepath.Join(dir, "redirector.sock")
+	port := freePort(t)
+	tcp := dialClient("tcp", "127.0.0.1:"+port)
+	s := startCommand(t, tcp, command(nil, "-listen=127.0.0.1:"+port+",unix:"+sock,
+		"rsc.io/*", "https://github.com/rsc/*"))
+	for name, client := range map[string]*http.Client{"tcp": tcp, "unix": unixClient(sock)} {
+		_, body := fetch(t, client, "http://rsc.io/pdf?go-get=1")
+		if meta, want := goImport(body), "rsc.io/pdf git https://github.com/rsc/pdf"; meta != want {
+			t.Errorf("GET rsc.io/pdf on the %s listener: go-import = %q, want %q", name, meta, want)
+		}
+	}
+
+	// Shutting down stops both.
+	s.stop(t, syscall.SIGTERM)
+	if conn, err := net.Dial("tcp", "127.0.0.1:"+port); err == nil {
+		conn.Close()
+		t.Errorf("still listening on port %s after shutdown", port)
+	}
+	if _, err := os.Lstat(sock); !os.IsNotExist(err) {
+		t.Errorf("socket %s still exists after shutdown: %v", sock, err)
+	}
+}
+
+// newRedirects returns redirects for the given import path and repo pairs.
+func newRedirects(t *testing.T, pairs ...string) []*redirector.Redirect {
+	t.Helper()
+	var redirects []*redirector.Redirect
+	for i := 0; i+1 < len(pairs); i += 2 {
+		r, err := redirector.NewRedirect(redirector.Entry{ImportPath: pairs[i], Repo: pairs[i+1]}, nil)
+		if err != nil {
+			t.Fatalf("NewRedirect(%s, %s) failed: %v", pairs[i], pairs[i+1], err)
+		}
+		redirects = append(redirects, r)
+	}
+	return redirects
+}
+
+func TestHSTS(t *testing.T) {
+	router := newTestRouter(t, "rsc.io/*", "https://github.com/rsc/*")
+	tests := []struct {
+		subdomains bool
+		want       string
+	}{
+		{false, "max-age=31536000"},
+		{true, "max-age=31536000; includeSubDomains"},
+	}
+	for _, tt := range tests {
+		h := hsts(router, 365*24*time.Hour, tt.subdomains)
+		// The header is sent on pages, redirects, and errors alike.
+		for _, target := range []string{"rsc.io/pdf", "rsc.io/", "example.com/none"} {
+			if got := serve(h, target).Header().Get("Strict-Transport-Security"); got != tt.want {
+				t.Errorf("GET %s with subdomains %t: Strict-Transport-Security = %q, want %q", target, tt.subdomains, got, tt.want)
+			}
+		}
+	}
+
+	// The header is only added when -hsts is set.
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	sock := filepath.Join(dir, "redirector.sock")
+	for _, arg := range []string{"-hsts=0", "-hsts=1h"} {
+		s := startMain(t, sock, nil, "-listen=unix:"+sock, arg, "rsc.io/*", "https://github.com/rsc/*")
+		resp, _ := fetch(t, unixClient(sock), "http://rsc.io/pdf")
+		s.stop(t, syscall.SIGTERM)
+		want := ""
+		if arg == "-hsts=1h" {
+			want = "max-age=3600"
+		}
+		if got := resp.Header.Get("Strict-Transport-Security"); got != want {
+			t.Errorf("running with %s: Strict-Transport-Security = %q, want %q", arg, got, want)
+		}
+	}
+}
+
+func TestGodocLegacy(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, "https://pkg.go.dev/rsc.io/pdf/sub"},
+		{[]string{"-godoc-legacy"}, "https://godoc.org/rsc.io/pdf/sub"},
+	}
+	for _, tt := range tests {
+		_, body := fetchMain(t, "http://rsc.io/pdf/sub", append(tt.args, "rsc.io/*", "https://github.com/rsc/*")...)
+		if got := refresh(body); got != tt.want {
+			t.Errorf("running with %q: refresh = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+
+	out, err := runMain(t, nil, "-godoc-legacy", "-docs=https://docs.internal/", "rsc.io/*", "https://github.com/rsc/*")
+	if err == nil || !strings.Contains(out, "-godoc-legacy may not be combined with -docs") {
+		t.Errorf("running with -godoc-legacy and -docs = %v, %q; want an error", err, out)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	sock := filepath.Join(dir, "redirector.sock")
+	s := startMain(t, sock, nil, "-listen=unix:"+sock, "-grace=100ms", "rsc.io/*", "https://github.com/rsc/*")
+
+	// A client that never finishes sending its request keeps the server from shutting down
+	// gracefully.
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := io.WriteString(conn, "GET /pdf HTTP/1.1\r\nHost: rsc.io\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if err := s.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.cmd.Wait() }()
+	select {
+	case err = <-done:
+	case <-time.After(5 * time.Second):
+		s.cmd.Process.Kill()
+		<-done
+		t.Fatalf("server did not exit after the grace period:\n%s", s.out.String())
+	}
+	out := s.out.String()
+	if exit, ok := err.(*exec.ExitError); !ok || exit.ExitCode() != 1 {
+		t.Errorf("server exited with %v, want exit status 1", err)
+	}
+	if !strings.Contains(out, "error shutting down gracefully, closing remaining connections: ") {
+		t.Errorf("output %q does not report the shutdown error", out)
+	}
+	if strings.Contains(out, "panic") {
+		t.Errorf("server panicked on a shutdown error:\n%s", out)
+	}
+}
+
+func TestPrintRedirects(t *testing.T) {
+	var buf bytes.Buffer
+	printRedirects(&buf, newRedirects(t,
+		"rsc.io/*", "https://github.com/rsc/*",
+		"9fans.net/go", "hg+https://hg.example.com/go"))
+	want := "IMPORT        VCS  REPO\n" +
+		"9fans.net/go  hg   https://hg.example.com/go\n" +
+		"rsc.io/*      git  https://github.com/rsc/*\n"
+	if got := buf.String(); got != want {
+		t.Errorf("printRedirects wrote:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	sock := filepath.Join(dir, "redirector.sock")
+	config := writeFile(t, dir, "redirects.yaml", `- import: rsc.io/*
+  repo: https://github.com/rsc/*
+- import: 9fans.net/go
+  repo: https://github.com/9fans/go
+`)
+	out, err := runMain(t, nil, "-check", "-listen=unix:"+sock, "-config="+config)
+	if err != nil {
+		t.Fatalf("running with -check failed: %v\n%s", err, out)
+	}
+	for _, s := range []string{"IMPORT", "rsc.io/*", "https://github.com/9fans/go"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("-check output %q does not contain %q", out, s)
+		}
+	}
+	if _, err := os.Lstat(sock); !os.IsNotExist(err) {
+		t.Errorf("-check created the socket %s: %v", sock, err)
+	}
+
+	writeFile(t, dir, "redirects.yaml", `- import: rsc.io/*
+  repo: https://github.com/rsc/*
+- import: 9fans.net/go
+  repo: github.com/9fans/go
+`)
+	out, err = runMain(t, nil, "-check", "-listen=unix:"+sock, "-config="+config)
+	if err == nil {
+		t.Errorf("running with -check and an invalid entry succeeded:\n%s", out)
+	}
+	if want := "redirects.yaml:3: error creating redirect 9fans.net/go -> github.com/9fans/go"; !strings.Contains(out, want) {
+		t.Errorf("-check output %q does not contain %q", out, want)
+	}
+}
+
+func TestQuiet(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	sock := filepath.Join(dir, "redirector.sock")
+	for _, quiet := range []bool{false, true} {
+		s := startMain(t, sock, nil, "-listen=unix:"+sock, "-quiet="+strconv.FormatBool(quiet),
+			"rsc.io/*", "https://github.com/rsc/*")
+		fetch(t, unixClient(sock), "http://rsc.io/pdf")
+		out := s.stop(t, syscall.SIGTERM)
+		if logged := strings.Contains(out, "shutting down"); logged == quiet {
+			t.Errorf("with -quiet=%t, output is %q", quiet, out)
+		}
+		if quiet && out != "" {
+			t.Errorf("with -quiet, output is %q, want nothing", out)
+		}
+	}
+
+	out, err := runMain(t, nil, "-quiet", "-log-format=json", "rsc.io/*", "https://github.com/rsc/*")
+	if err == nil || !strings.Contains(out, "-quiet may not be combined with -v or -log-format") {
+		t.Errorf("running with -quiet and -log-format = %v, %q; want an error", err, out)
+	}
+}
+
+func TestListenFailure(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	sock := filepath.Join(dir, "redirector.sock")
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	addr := busy.Addr().String()
+
+	out, err := runMain(t, nil, "-listen=unix:"+sock+","+addr, "rsc.io/*", "https://github.com/rsc/*")
+	if exit, ok := err.(*exec.ExitError); !ok || exit.ExitCode() != 1 {
+		t.Errorf("running with a busy address exited with %v, want exit status 1", err)
+	}
+	if want := "error creating listener for " + addr + ": "; !strings.Contains(out, want) {
+		t.Errorf("output %q does not contain %q", out, want)
+	}
+	if strings.Contains(out, sock+":") || strings.Contains(out, "panic") {
+		t.Errorf("output %q blames the wrong listener or panics", out)
+	}
+	// The listener created before the failure is cleaned up.
+	if _, err := os.Lstat(sock); !os.IsNotExist(err) {
+		t.Errorf("socket %s still exists after the failure: %v", sock, err)
+	}
+}
+
+// setenv sets the environment variable key to value, or unsets it if value is empty, and returns
+// a function restoring its previous value.
+func setenv(key, value string) func() {
+	old, ok := os.LookupEnv(key)
+	if value == "" {
+		os.Unsetenv(key)
+	} else {
+		os.Setenv(key, value)
+	}
+	return func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+func TestLoadCertificateEnv(t *testing.T) {
+	certPEM, keyPEM := generateCert(t)
+	defer func(cert, key string) { *tlsCert, *tlsKey = cert, key }(*tlsCert, *tlsKey)
+	*tlsCert, *tlsKey = "", ""
+
+	tests := []struct {
+		cert, key string
+		ok        bool
+	}{
+		{string(certPEM), string(keyPEM), true},
+		{string(certPEM), "", false},
+		{"", string(keyPEM), false},
+		{string(keyPEM), string(certPEM), false},
+	}
+	for _, tt := range tests {
+		restoreCert, restoreKey := setenv("TLS_CERT_PEM", tt.cert), setenv("TLS_KEY_PEM", tt.key)
+		cert, err := loadCertificate()
+		restoreCert()
+		restoreKey()
+		if (cert != nil && err == nil) != tt.ok {
+			t.Errorf("loadCertificate() with TLS_CERT_PEM set %t and TLS_KEY_PEM set %t = %v, %v; want success %t",
+				tt.cert != "", tt.key != "", cert, err, tt.ok)
+		}
+	}
+
+	// Files given by flags take precedence over the environment.
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	*tlsCert = writeFile(t, dir, "cert.pem", string(certPEM))
+	*tlsKey = writeFile(t, dir, "key.pem", string(keyPEM))
+	defer setenv("TLS_CERT_PEM", "not a certificate")()
+	defer setenv("TLS_KEY_PEM", "not a key")()
+	if cert, err := loadCertificate(); cert == nil || err != nil {
+		t.Errorf("loadCertificate() with files and a bad environment = %v, %v; want the files' certificate", cert, err)
+	}
+}
+
+func TestServeTLSEnv(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	certPEM, keyPEM := generateCert(t)
+	sock := filepath.Join(dir, "redirector.sock")
+	env := []string{"TLS_CERT_PEM=" + string(certPEM), "TLS_KEY_PEM=" + string(keyPEM)}
+	s := startMain(t, sock, env, "-listen=unix:"+sock, "rsc.io/*", "https://github.com/rsc/*")
+	defer s.stop(t, syscall.SIGTERM)
+	resp, body := fetch(t, unixClient(sock), "https://rsc.io/pdf?go-get=1")
+	if resp.TLS == nil {
+		t.Errorf("GET https://rsc.io/pdf was not served over TLS")
+	}
+	if meta, want := goImport(body), "rsc.io/pdf git https://github.com/rsc/pdf"; meta != want {
+		t.Errorf("GET https://rsc.io/pdf: go-import = %q, want %q", meta, want)
+	}
+
+	// A bad pair fails at startup.
+	out, err := runMain(t, []string{"TLS_CERT_PEM=" + string(certPEM), "TLS_KEY_PEM=" + string(certPEM)},
+		"-listen=unix:"+sock, "rsc.io/*", "https://github.com/rsc/*")
+	if err == nil || !strings.Contains(out, "error loading TLS certificate") {
+		t.Errorf("running with a bad TLS_KEY_PEM = %v, %q; want an error", err, out)
+	}
+}
+
+func TestShutdownDrain(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	sock := filepath.Join(dir, "redirector.sock")
+	s := startMain(t, sock, nil, "-listen=unix:"+sock, "-shutdown-drain", "-drain-mode=serve", "-grace=1s",
+		"rsc.io/*", "https://github.com/rsc/*")
+	client := unixClient(sock)
+	if resp, _ := fetch(t, client, "http://lb.internal/healthz"); resp.StatusCode != http.StatusOK {
+		t.Errorf("health check before shutdown: status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	if err := s.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		resp, _ := fetch(t, client, "http://lb.internal/healthz")
+		if resp.StatusCode == http.StatusServiceUnavailable {
+			break
+		}
+		if time.Since(start) > 500*time.Millisecond {
+			t.Fatalf("health check after SIGTERM: status = %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+		}
+	}
+	// Redirects are still served while draining.
+	resp, body := fetch(t, client, "http://rsc.io/pdf?go-get=1")
+	if meta, want := goImport(body), "rsc.io/pdf git https://github.com/rsc/pdf"; resp.StatusCode != http.StatusOK || meta != want {
+		t.Errorf("GET rsc.io/pdf while draining = %d with go-import %q, want %d with %q",
+			resp.StatusCode, meta, http.StatusOK, want)
+	}
+	if err := s.cmd.Wait(); err != nil {
+		t.Errorf("server exited with an error: %v\n%s", err, s.out.String())
+	}
+}
+
+func TestH2C(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	sock := filepath.Join(dir, "redirector.sock")
+	s := startMain(t, sock, nil, "-listen=unix:"+sock, "-h2c", "rsc.io/*", "https://github.com/rsc/*")
+
+	// An HTTP/2 client speaks h2c when allowed plain HTTP and given a plain connection.
+	client := &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(_, _ string, _ *tls.Config) (net.Conn, error) {
+				return net.Dial("unix", sock)
+			},
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, body := fetch(t, client, "http://rsc.io/pdf?go-get=1")
+	if resp.ProtoMajor != 2 {
+		t.Errorf("GET http://rsc.io/pdf: protocol = %s, want HTTP/2", resp.Proto)
+	}
+	if meta, want := goImport(body), "rsc.io/pdf git https://github.com/rsc/pdf"; meta != want {
+		t.Errorf("GET http://rsc.io/pdf: go-import = %q, want %q", meta, want)
+	}
+
+	// HTTP/1.1 is still served alongside.
+	resp, _ = fetch(t, unixClient(sock), "http://rsc.io/pdf?go-get=1")
+	if resp.ProtoMajor != 1 {
+		t.Errorf("GET http://rsc.io/pdf over HTTP/1.1: protocol = %s", resp.Proto)
+	}
+
+	// The server shuts down cleanly with the h2c connection still open.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		out := s.stop(t, syscall.SIGTERM)
+		if !strings.Contains(out, "shutting down") {
+			t.Errorf("output of server is %q, want it to shut down", out)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		s.cmd.Process.Kill()
+		<-done
+		t.Errorf("server didn't shut down with an h2c connection open")
+	}
+
+	certPEM, keyPEM := generateCert(t)
+	certFile := writeFile(t, dir, "cert.pem", string(certPEM))
+	keyFile := writeFile(t, dir, "key.pem", string(keyPEM))
+	out, err := runMain(t, nil, "-listen=unix:"+sock, "-h2c", "-tls-cert="+certFile, "-tls-key="+keyFile,
+		"rsc.io/*", "https://github.com/rsc/*")
+	if err == nil || !strings.Contains(out, "-h2c may not be combined with TLS") {
+		t.Errorf("running with -h2c and TLS = %v, %q; want an error", err, out)
+	}
+}
+
+func TestSameHost(t *testing.T) {
+	tests := []struct {
+		importPath string
+		repo       string
+		same       bool
+	}{
+		{"example.com/x", "https://example.com/x", true},
+		{"Example.COM/x", "https://example.com/x", true},
+		{"example.com/*", "https://example.com:8443/git/*", true},
+		{"example.com/x", "https://git.example.com/x", false},
+		{"example.com/x", "https://github.com/example/x", false},
+		{"rsc.io/*", "https://github.com/rsc/*", false},
+	}
+	for _, tt := range tests {
+		r := newRedirects(t, tt.importPath, tt.repo)[0]
+		if same := sameHost(r); same != tt.same {
+			t.Errorf("sameHost(%s -> %s) = %t, want %t", tt.importPath, tt.repo, same, tt.same)
+		}
+	}
+
+	out, err := runMain(t, nil, "-check", "example.com/x", "https://example.com/x")
+	if err != nil {
+		t.Fatalf("running with a repo on the import path's host failed: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "import path example.com/x has repo https://example.com/x on the same host") {
+		t.Errorf("running with a repo on the import path's host: output %q has no warning", out)
+	}
+	out, err = runMain(t, nil, "-check", "rsc.io/*", "https://github.com/rsc/*")
+	if err != nil || strings.Contains(out, "same host") {
+		t.Errorf("running with a repo on another host = %v, %q; want no warning", err, out)
+	}
+	out, err = runMain(t, nil, "-check", "-strict", "example.com/x", "https://example.com/x")
+	if err == nil || !strings.Contains(out, "on the same host") {
+		t.Errorf("running with -strict and a repo on the import path's host = %v, %q; want an error", err, out)
+	}
+}
+
+func TestVCSAliasFlag(t *testing.T) {
+	_, body := fetchMain(t, "http://rsc.io/pdf?go-get=1", "-vcs-alias=github=git", "-vcs-alias=mercurial=hg",
+		"rsc.io/pdf", "github+https://github.com/rsc/pdf")
+	if meta, want := goImport(body), "rsc.io/pdf git https://github.com/rsc/pdf"; meta != want {
+		t.Errorf("GET rsc.io/pdf with -vcs-alias github=git: go-import = %q, want %q", meta, want)
+	}
+
+	for _, alias := range []string{"github", "=git", "github="} {
+		out, err := runMain(t, nil, "-vcs-alias="+alias, "rsc.io/pdf", "github+https://github.com/rsc/pdf")
+		if err == nil || !strings.Contains(out, "alias must be of the form from=to") {
+			t.Errorf("running with -vcs-alias %q = %v, %q; want an error", alias, err, out)
+		}
+	}
+	out, err := runMain(t, nil, "rsc.io/pdf", "github+https://github.com/rsc/pdf")
+	if err == nil || !strings.Contains(out, `unknown version control system "github"`) {
+		t.Errorf("running with an unaliased github VCS = %v, %q; want an error", err, out)
+	}
+}
+
+func TestReusePort(t *testing.T) {
+	if reusePortControl == nil {
+		out, err := runMain(t, nil, "-listen=127.0.0.1:"+freePort(t), "-reuseport", "rsc.io/*", "https://github.com/rsc/*")
+		if err == nil || !strings.Contains(out, "-reuseport is not supported on this platform") {
+			t.Errorf("running with -reuseport = %v, %q; want an error", err, out)
+		}
+		return
+	}
+
+	addr := "127.0.0.1:" + freePort(t)
+	client := dialClient("tcp", addr)
+	args := []string{"-listen=" + addr, "-reuseport", "rsc.io/*", "https://github.com/rsc/*"}
+	first := startCommand(t, client, command(nil, args...))
+	defer first.stop(t, syscall.SIGTERM)
+
+	// Without -reuseport, a second instance can't bind the port.
+	out, err := runMain(t, nil, "-listen="+addr, "rsc.io/*", "https://github.com/rsc/*")
+	if err == nil || !strings.Contains(out, "error creating listener for "+addr) {
+		t.Errorf("running a second instance without -reuseport = %v, %q; want an error", err, out)
+	}
+
+	// With it, both instances share the port, and either may answer. The second also listens on a
+	// unix socket, so that it alone is waited for.
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	sock := filepath.Join(dir, "redirector.sock")
+	args[0] += ",unix:" + sock
+	second := startCommand(t, unixClient(sock), command(nil, args...))
+	resp, body := fetch(t, client, "http://rsc.io/pdf?go-get=1")
+	if resp.StatusCode != http.StatusOK || goImport(body) != "rsc.io/pdf git https://github.com/rsc/pdf" {
+		t.Errorf("GET rsc.io/pdf with two instances = %d, %q", resp.StatusCode, body)
+	}
+	second.stop(t, syscall.SIGTERM)
+
+	// The first instance still serves once the second is gone.
+	resp, _ = fetch(t, dialClient("tcp", addr), "http://rsc.io/pdf?go-get=1")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET rsc.io/pdf after stopping the second instance: status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestMaxRules(t *testing.T) {
+	pairs := []string{
+		"rsc.io/*", "https://github.com/rsc/*",
+		"9fans.net/go", "https://github.com/9fans/go",
+		"example.com/pkg", "https://github.com/example/pkg",
+	}
+	tests := []struct {
+		max  int
+		fail bool
+	}{
+		{3, false},
+		{2, true},
+	}
+	for _, tt := range tests {
+		out, err := runMain(t, nil, append([]string{"-check", "-max-rules=" + strconv.Itoa(tt.max)}, pairs...)...)
+		if failed := err != nil; failed != tt.fail {
+			t.Errorf("running with 3 redirects and -max-rules %d = %v, %q; want failure %t", tt.max, err, out, tt.fail)
+		}
+		if tt.fail && !strings.Contains(out, "too many redirects: 3 exceeds -max-rules 2") {
+			t.Errorf("running with 3 redirects and -max-rules %d: output %q does not explain the failure", tt.max, out)
+		}
+	}
+
+	// Sub-routes count as redirects of their own.
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	config := writeFile(t, dir, "redirects.yaml", `- import: example.com/*
+  repo: https://github.com/example/*
+  routes:
+    - path: legacy
+      repo: https://hg.example.com/legacy
+      vcs: hg
+`)
+	out, err := runMain(t, nil, "-check", "-max-rules=1", "-config="+config)
+	if err == nil || !strings.Contains(out, "too many redirects: 2 exceeds -max-rules 1") {
+		t.Errorf("running with an entry with a sub-route and -max-rules 1 = %v, %q; want an error", err, out)
+	}
+}
+
+func TestAutocert(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	cache := filepath.Join(dir, "autocert-cache")
+	m := newAutocertManager([]string{"rsc.io", "9fans.net"}, cache)
+
+	// Certificates are only requested for the listed hosts.
+	for host, allowed := range map[string]bool{"rsc.io": true, "9fans.net": true, "example.com": false, "sub.rsc.io": false} {
+		if err := m.HostPolicy(context.Background(), host); (err == nil) != allowed {
+			t.Errorf("HostPolicy(%s) = %v, want allowed %t", host, err, allowed)
+		}
+	}
+	_, err := m.TLSConfig().GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	if err == nil {
+		t.Errorf("GetCertificate(example.com) succeeded, want an error for a host not listed")
+	}
+	if c, ok := m.Cache.(autocert.DirCache); !ok || string(c) != cache {
+		t.Errorf("cache = %#v, want the directory %s", m.Cache, cache)
+	}
+	if m := newAutocertManager([]string{"rsc.io"}, ""); m.Cache != nil {
+		t.Errorf("cache with no directory = %#v, want none", m.Cache)
+	}
+
+	// The HTTP handler answers ACME challenges and passes every other request to the redirects.
+	h := m.HTTPHandler(newTestRouter(t, "rsc.io/*", "https://github.com/rsc/*"))
+	w := serve(h, "rsc.io/pdf?go-get=1")
+	if meta, want := goImport(w.Body.String()), "rsc.io/pdf git https://github.com/rsc/pdf"; w.Code != http.StatusOK || meta != want {
+		t.Errorf("GET rsc.io/pdf over HTTP = %d with go-import %q, want %d with %q", w.Code, meta, http.StatusOK, want)
+	}
+	w = serve(h, "rsc.io/.well-known/acme-challenge/unknown-token")
+	if w.Code != http.StatusNotFound || goImport(w.Body.String()) != "" {
+		t.Errorf("GET an unknown ACME challenge = %d with %q, want a 404 from the manager", w.Code, w.Body.String())
+	}
+
+	certPEM, keyPEM := generateCert(t)
+	certFile := writeFile(t, dir, "cert.pem", string(certPEM))
+	keyFile := writeFile(t, dir, "key.pem", string(keyPEM))
+	out, err := runMain(t, nil, "-autocert-hosts=rsc.io", "-tls-cert="+certFile, "-tls-key="+keyFile,
+		"rsc.io/*", "https://github.com/rsc/*")
+	if err == nil || !strings.Contains(out, "-autocert-hosts may not be combined with -tls-cert and -tls-key") {
+		t.Errorf("running with -autocert-hosts and -tls-cert = %v, %q; want an error", err, out)
+	}
+}
@@ -0,0 +1,60 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	requestsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "goimport_requests_total",
+		Help: "Total number of requests handled.",
+	})
+	// Requests are counted by the import path they matched as it was given, such as rsc.io/*,
+	// rather than the import root they resolved to, so that the number of series is bounded by
+	// the redirects served instead of by the paths clients request.
+	importPathRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "goimport_import_path_requests_total",
+		Help: "Number of requests served by each import path.",
+	}, []string{"import_path"})
+	notFoundTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "goimport_not_found_total",
+		Help: "Number of requests answered with 404 Not Found.",
+	})
+	requestDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "goimport_request_duration_seconds",
+		Help:    "Time taken to handle requests.",
+		Buckets: prometheus.DefBuckets,
+	})
+)
+
+// metrics returns a handler that serves Prometheus metrics at path on any host and records
+// metrics for every other request passed to next.
+func metrics(next http.Handler, path string) http.Handler {
+	prometheus.MustRegister(requestsTotal, importPathRequests, notFoundTotal, requestDuration)
+	scrape := promhttp.Handler()
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == path {
+			scrape.ServeHTTP(w, req)
+			return
+		}
+
+		start := time.Now()
+		aw := &accessWriter{ResponseWriter: w}
+		next.ServeHTTP(aw, req)
+		requestDuration.Observe(time.Since(start).Seconds())
+		requestsTotal.Inc()
+		if aw.status == http.StatusNotFound {
+			notFoundTotal.Inc()
+		}
+		if aw.pattern != "" {
+			importPathRequests.WithLabelValues(aw.pattern).Inc()
+		}
+	})
+}
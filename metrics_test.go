@@ -0,0 +1,58 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"go.spiff.io/go-import-redirector/redirector"
+)
+
+func TestMetrics(t *testing.T) {
+	// The metrics are registered globally, so this is the only test that may call metrics.
+	h := metrics(newTestRouter(t, "rsc.io/*", "https://github.com/rsc/*"), "/metrics")
+	for _, target := range []string{"rsc.io/pdf", "rsc.io/x86/x86asm", "example.com/none"} {
+		serve(h, target)
+	}
+
+	// Metrics are served on any host, ahead of the import paths there.
+	body := serve(h, "rsc.io/metrics").Body.String()
+	for _, line := range []string{
+		"goimport_requests_total 3",
+		"goimport_not_found_total 1",
+		// Requests are counted by pattern, not by the import root they resolved to.
+		`goimport_import_path_requests_total{import_path="rsc.io/*"} 2`,
+		"goimport_request_duration_seconds_count 3",
+	} {
+		if !strings.Contains(body, "\n"+line+"\n") {
+			t.Errorf("metrics do not contain %q:\n%s", line, body)
+		}
+	}
+	if strings.Contains(body, `import_path="rsc.io/pdf"`) {
+		t.Errorf("metrics are labelled by import root:\n%s", body)
+	}
+
+	// Scrapes are not counted as requests.
+	body = serve(h, "example.com/metrics").Body.String()
+	if !strings.Contains(body, "\ngoimport_requests_total 3\n") {
+		t.Errorf("scrape was counted as a request:\n%s", body)
+	}
+}
+
+func TestBuildRouterMetricsPath(t *testing.T) {
+	defer func(p string) { *metricsPath = p }(*metricsPath)
+	*metricsPath = "/metrics"
+	opts := &redirector.Options{}
+
+	// An import path covering the metrics path is allowed, with the metrics taking precedence.
+	if _, err := buildRouter(newRedirects(t, "example.com/*", "https://github.com/example/*"), opts); err != nil {
+		t.Errorf("buildRouter with example.com/* failed: %v", err)
+	}
+	// One that is exactly the metrics path is refused.
+	_, err := buildRouter(newRedirects(t, "example.com/metrics", "https://github.com/example/metrics"), opts)
+	if err == nil || !strings.Contains(err.Error(), "-metrics-path") {
+		t.Errorf("buildRouter with example.com/metrics = %v, want a -metrics-path error", err)
+	}
+}
@@ -0,0 +1,60 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package redirector
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"net/http"
+	"strconv"
+)
+
+// NotFoundData is passed to the NotFoundTemplate.
+type NotFoundData struct {
+	Host  string
+	Path  string
+	Roots []string // known import paths, sorted
+}
+
+type rootsKey struct{}
+
+// withRoots returns req with roots attached for use by notFound.
+func withRoots(req *http.Request, roots []string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), rootsKey{}, roots))
+}
+
+// ParseNotFoundTemplate parses the html/template in file for use as a NotFoundTemplate, and checks
+// that it can be executed with a NotFoundData.
+func ParseNotFoundTemplate(file string) (*template.Template, error) {
+	return parseTemplateFile(file, &NotFoundData{
+		Host:  "example.com",
+		Path:  "/pkg",
+		Roots: []string{"example.com/other"},
+	})
+}
+
+// notFound writes a 404 response for req, using the NotFoundTemplate if set.
+func (o *Options) notFound(w http.ResponseWriter, req *http.Request) {
+	if o.NotFoundTemplate == nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	roots, _ := req.Context().Value(rootsKey{}).([]string)
+	var buf bytes.Buffer
+	err := o.NotFoundTemplate.Execute(&buf, &NotFoundData{
+		Host:  requestHost(req),
+		Path:  req.URL.Path,
+		Roots: roots,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(http.StatusNotFound)
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,958 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package redirector implements the handlers used by go-import-redirector to answer ``go get''
+// requests for custom import paths with a go-import meta tag pointing at the source repository,
+// and to send browsers to the package's documentation.
+//
+// A handler for a set of redirects is created with NewHandler:
+//
+//	h, err := redirector.NewHandler([]redirector.Entry{
+//		{ImportPath: "rsc.io/*", Repo: "https://github.com/rsc/*"},
+//		{ImportPath: "9fans.net/go", Repo: "https://github.com/9fans/go"},
+//	}, &redirector.Options{DocsBase: "https://pkg.go.dev/"})
+//
+// See the go-import-redirector command for a description of each option.
+package redirector
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// knownVCS is the set of version control systems understood by go get. The mod pseudo-VCS names a
+// module proxy serving the import root rather than a repository.
+var knownVCS = map[string]bool{
+	"bzr":    true,
+	"fossil": true,
+	"git":    true,
+	"hg":     true,
+	"mod":    true,
+	"svn":    true,
+}
+
+// Options controls how redirects are served. The zero value serves only go-import meta tags,
+// without documentation redirects.
+type Options struct {
+	// DefaultVCS is the version control system of repos whose URL has no VCS prefix. If empty,
+	// git is used.
+	DefaultVCS string
+	// VCSAliases maps version control system names to the ones served, and is applied before
+	// checking that the version control system is known to go get.
+	VCSAliases map[string]string
+
+	// DocsBase is the base URL of the documentation host, ending in a slash. If empty,
+	// documentation redirects are disabled.
+	DocsBase string
+	// DocsTemplate builds documentation URLs from a Data, with the import root and suffix
+	// percent-encoded. If nil, the URL is DocsBase followed by the import root and suffix.
+	DocsTemplate *texttemplate.Template
+	// RootDocs is where browsers are sent for a request at an import root itself: "pkg" (or
+	// empty) for its documentation, or "repo" for its repository.
+	RootDocs string
+
+	// GoGetTemplate and BrowserTemplate are the templates used for requests from go get and for
+	// all other requests, respectively. If nil, DefaultTemplate is used.
+	GoGetTemplate   *template.Template
+	BrowserTemplate *template.Template
+	// NotFoundTemplate, if set, is used for 404 responses to requests matching no redirect. It is
+	// executed with a NotFoundData.
+	NotFoundTemplate *template.Template
+
+	// WildcardRoot is the response to a request for the bare root of a wildcard import path:
+	// "docs" (or empty), "404", "204", or a URL to redirect to.
+	WildcardRoot string
+	// WildcardDepth is the number of path elements substituted for a wildcard. If less than 1, 1
+	// is used.
+	WildcardDepth int
+	// MajorRoots includes a major version element such as /v2 following an import root in the
+	// root.
+	MajorRoots bool
+	// GopkgVersions removes a gopkg.in-style version, such as the .v2 of pkg.v2, from the wildcard
+	// element of a request before substituting it in the repo URL, and serves it as the ref.
+	GopkgVersions bool
+
+	// MaxPathLen is the longest request path served, in bytes, if greater than zero.
+	MaxPathLen int
+	// StrictMethods rejects requests using methods other than GET and HEAD.
+	StrictMethods bool
+	// StrictQuery rejects requests with query parameters other than go-get=1.
+	StrictQuery bool
+	// StrictGoGet redirects requests not from go get instead of serving them a page.
+	StrictGoGet bool
+	// Permanent uses 301 Moved Permanently instead of 302 Found for direct redirects.
+	Permanent bool
+	// CacheMaxAge, if greater than zero, allows clients to cache pages and direct redirects for
+	// that long.
+	CacheMaxAge time.Duration
+
+	// ManualRedirect omits the refresh meta tag from pages.
+	ManualRedirect bool
+	// Canonical adds a canonical link to the documentation to pages.
+	Canonical bool
+	// JSONLD adds a JSON-LD description of the package to pages.
+	JSONLD bool
+	// Minify removes whitespace between tags in the head of pages.
+	Minify bool
+
+	// HealthPath, if set, is answered with a 200 OK on any host where it matches no redirect.
+	HealthPath string
+	// HealthCheck, if set, is called for each request for HealthPath, which is answered with a 503
+	// Service Unavailable instead if it returns an error.
+	HealthCheck func() error
+	// Robots, if set, is served for /robots.txt on any host, taking precedence over wildcard and
+	// other import paths covering it unless one is exactly that path.
+	Robots string
+	// Index serves a page listing all redirects for / on any host where it matches no redirect.
+	Index bool
+
+	// Debugf, if set, is called to log debug messages about the handling of req.
+	Debugf func(req *http.Request, format string, args ...interface{})
+}
+
+func (o *Options) debugf(req *http.Request, format string, args ...interface{}) {
+	if o.Debugf != nil {
+		o.Debugf(req, format, args...)
+	}
+}
+
+// setCacheControl sets the Cache-Control header of a page or direct redirect.
+func (o *Options) setCacheControl(w http.ResponseWriter) {
+	if o.CacheMaxAge > 0 {
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.FormatInt(int64(o.CacheMaxAge/time.Second), 10))
+	}
+}
+
+// redirectStatus returns the status used for direct redirects.
+func (o *Options) redirectStatus() int {
+	if o.Permanent {
+		return http.StatusMovedPermanently
+	}
+	return http.StatusFound
+}
+
+// Entry describes a redirect from an import path to a repository.
+type Entry struct {
+	// ImportPath is the import path, such as 9fans.net/go, or rsc.io/* to serve every import path
+	// under rsc.io. An import path ending in /** takes the full remaining path of a request as the
+	// wildcard, so that example.com/group/subgroup/pkg has the import root of that whole path, not
+	// example.com/group/subgroup; it can't serve packages below the import root. Use a /* wildcard
+	// with Depth or the WildcardDepth option for those.
+	ImportPath string
+	// Repo is the repository URL, such as https://github.com/9fans/go, which must end in /* or /**
+	// if ImportPath does. Instead, a wildcard Repo may place the wildcard element anywhere in its
+	// path with an {elem} placeholder, as in https://git.example.com/{elem}.git. It may begin with
+	// a version control system and a plus, as in git+https://github.com/9fans/go, and may end in
+	// a #ref fragment.
+	Repo string
+	// VCS is the version control system used if Repo has no VCS prefix. If empty, the
+	// DefaultVCS option is used.
+	VCS string
+	// Docs, if set, is the documentation base URL used in place of the DocsBase option.
+	Docs string
+	// NoDocs disables documentation redirects for the entry whatever the DocsBase option, such as
+	// for internal packages with no public documentation, so that only its meta tags are served.
+	// It may not be combined with Docs.
+	NoDocs bool
+	// Message, if set, is shown to browsers on the entry's page in place of the text linking to
+	// its documentation, such as a note that the package is in maintenance mode.
+	Message string
+	// Deprecated, if set, marks the entry's packages as being retired since that date, given in a
+	// Deprecation header on its responses. Sunset, if set, is the date after which they may no
+	// longer be served, given in a Sunset header (RFC 8594), and Successor is the URL of their
+	// replacement, given in a Link header with rel="successor-version". Neither may be set
+	// without Deprecated.
+	Deprecated time.Time
+	Sunset     time.Time
+	Successor  string
+	// Depth, if greater than zero, is the number of path elements taken from a request for a /*
+	// wildcard, in place of the WildcardDepth option. This places the import root of a host with
+	// groups nested to a known depth, with any further elements taken as a package within it.
+	Depth int
+	// SourceDir and SourceFile are URL templates for source directories and files, served in a
+	// go-source meta tag. They must be given together. In both, {elem} is replaced with the
+	// wildcard element of the request and {ref} with the ref from Repo.
+	SourceDir  string
+	SourceFile string
+	// Routes are sub-routes serving paths under ImportPath from other repositories. Each is served
+	// as an import path of its own, so the most specific one matching a request is used.
+	Routes []Route
+}
+
+// A Route serves a path under the import path of an Entry from another repository, such as for
+// packages kept in a legacy repository under a shared root.
+type Route struct {
+	// Path is the path under the entry's import path, without any wildcard, such as legacy.
+	Path string
+	// Repo is the repository URL, as for Entry.Repo but without a wildcard. Under an entry for a
+	// subdomain wildcard, a * in its path is replaced with the subdomain label of each request.
+	Repo string
+	// VCS is the version control system used if Repo has no VCS prefix. If empty, the entry's VCS
+	// is used.
+	VCS string
+}
+
+// Redirect serves a single Entry.
+type Redirect struct {
+	opts       *Options
+	wildcard   bool
+	deep       bool // wildcard takes all remaining path elements
+	importPath string
+	hostSuffix string // domain of a subdomain wildcard, with a leading dot
+	repo       *url.URL
+	repoElem   bool // repo path has an {elem} placeholder
+	depth      int  // path elements per wildcard, if not the WildcardDepth option
+	vcs        string
+	ref        string // branch or tag, from the repo URL fragment
+	docsBase   string
+	sourceDir  string // go-source directory URL template
+	sourceFile string // go-source file URL template
+	message    string // shown on the page in place of the docs link text
+
+	headers http.Header // Deprecation, Sunset, and Link headers, if deprecated
+}
+
+// NewRedirect returns a Redirect serving e with opts. If opts is nil, the zero Options are used.
+// It is an error for e to have Routes; use NewRedirects for those.
+func NewRedirect(e Entry, opts *Options) (*Redirect, error) {
+	if len(e.Routes) > 0 {
+		return nil, errors.New("entry with routes must be served with NewRedirects")
+	}
+	return newRedirect(e, opts, false)
+}
+
+// NewRedirects returns the Redirects serving e with opts: one for e itself, followed by one for
+// each of its Routes. The Redirect for a route uses the route's VCS, or else e's, and e's Docs,
+// NoDocs, and deprecation.
+func NewRedirects(e Entry, opts *Options) ([]*Redirect, error) {
+	r, err := newRedirect(e, opts, false)
+	if err != nil {
+		return nil, err
+	}
+	redirects := []*Redirect{r}
+	if len(e.Routes) == 0 {
+		return redirects, nil
+	}
+
+	root := strings.TrimSuffix(e.ImportPath, "/**")
+	root = strings.TrimSuffix(strings.TrimSuffix(root, "/*"), "/")
+	for _, route := range e.Routes {
+		p := strings.Trim(route.Path, "/")
+		if p == "" {
+			return nil, errors.New("route path is required")
+		}
+		sub := Entry{
+			ImportPath: root + "/" + p,
+			Repo:       route.Repo,
+			VCS:        route.VCS,
+			Docs:       e.Docs,
+			NoDocs:     e.NoDocs,
+			Deprecated: e.Deprecated,
+			Sunset:     e.Sunset,
+			Successor:  e.Successor,
+		}
+		if sub.VCS == "" {
+			sub.VCS = e.VCS
+		}
+		r, err := newRedirect(sub, opts, true)
+		if err != nil {
+			return nil, fmt.Errorf("route %s -> %s: %v", sub.ImportPath, sub.Repo, err)
+		}
+		redirects = append(redirects, r)
+	}
+	return redirects, nil
+}
+
+// newRedirect returns a Redirect serving e with opts, where e is a route of another entry if route
+// is true.
+func newRedirect(e Entry, opts *Options, route bool) (*Redirect, error) {
+	if opts == nil {
+		opts = new(Options)
+	}
+	importPath, repoPath := e.ImportPath, e.Repo
+	if importPath == "" {
+		return nil, errors.New("import path is required")
+	}
+
+	var ref string
+	if i := strings.IndexByte(repoPath, '#'); i >= 0 {
+		repoPath, ref = repoPath[:i], repoPath[i+1:]
+	}
+	if !strings.Contains(repoPath, "://") {
+		return nil, fmt.Errorf("repo path %q must be full URL", repoPath)
+	}
+	deep := strings.HasSuffix(importPath, "/**")
+	wildcard := deep || strings.HasSuffix(importPath, "/*")
+	repoElem := strings.Contains(repoPath, "{elem}")
+	switch {
+	case repoElem && !wildcard:
+		return nil, fmt.Errorf("import %q must end in /* or /** to use {elem} in repo %q", importPath, repoPath)
+	case repoElem && strings.HasSuffix(repoPath, "/*"):
+		return nil, fmt.Errorf("repo %q must not have both {elem} and a trailing wildcard", repoPath)
+	case repoElem:
+	case deep != strings.HasSuffix(repoPath, "/**"):
+		return nil, fmt.Errorf("either both import %q and repo %q must have /** or neither", importPath, repoPath)
+	case !deep && wildcard != strings.HasSuffix(repoPath, "/*"):
+		return nil, fmt.Errorf("either both import %q and repo %q must have /* or neither", importPath, repoPath)
+	}
+	switch {
+	case e.Depth < 0:
+		return nil, fmt.Errorf("invalid depth %d for import %q", e.Depth, importPath)
+	case e.Depth > 0 && (!wildcard || deep):
+		return nil, fmt.Errorf("import %q must end in /* to set a depth", importPath)
+	}
+	if deep {
+		importPath = strings.TrimSuffix(importPath, "/**")
+		repoPath = strings.TrimSuffix(repoPath, "/**")
+	} else if wildcard {
+		importPath = strings.TrimSuffix(importPath, "/*")
+		repoPath = strings.TrimSuffix(repoPath, "/*")
+	}
+
+	importPath = strings.TrimSuffix(importPath, "/")
+	if i := strings.IndexByte(importPath, '/'); i >= 0 {
+		importPath = strings.ToLower(importPath[:i]) + importPath[i:]
+	} else {
+		importPath = strings.ToLower(importPath)
+	}
+	repo, err := url.Parse(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	if repoElem && strings.Count(repo.Path, "{elem}") != strings.Count(repoPath, "{elem}") {
+		return nil, fmt.Errorf("repo %q may only use {elem} in its path", repoPath)
+	}
+
+	// An import path on *.domain serves each subdomain of domain, with the subdomain's label
+	// substituted for the * in the repo path.
+	host := importPath
+	if i := strings.IndexByte(host, '/'); i >= 0 {
+		host = host[:i]
+	}
+	var hostSuffix string
+	if strings.HasPrefix(host, "*.") {
+		hostSuffix = host[1:]
+		// A route may leave the * out, serving one repository for every subdomain.
+		stars := strings.Count(repoPath, "*")
+		if stars > 1 || stars != strings.Count(repo.Path, "*") || stars == 0 && !route {
+			return nil, fmt.Errorf("repo %q must have one * in its path for the subdomain of import %q", repoPath, e.ImportPath)
+		}
+	}
+	if strings.Contains(strings.TrimPrefix(host, "*."), "*") || hostSuffix == "." {
+		return nil, fmt.Errorf("import %q may only have a wildcard as the first label of a domain", e.ImportPath)
+	}
+
+	vcs := e.VCS
+	if vcs == "" {
+		vcs = opts.DefaultVCS
+	}
+	if vcs == "" {
+		vcs = "git"
+	}
+	if sep := strings.IndexByte(repo.Scheme, '+'); sep != -1 {
+		vcs, repo.Scheme = repo.Scheme[:sep], repo.Scheme[sep+1:]
+	}
+	if alias, ok := opts.VCSAliases[vcs]; ok {
+		vcs = alias
+	}
+	if !knownVCS[vcs] {
+		return nil, fmt.Errorf("unknown version control system %q for repo %q", vcs, repoPath)
+	}
+
+	docsBase := opts.DocsBase
+	if e.NoDocs {
+		if e.Docs != "" {
+			return nil, errors.New("docs and no docs may not be given together")
+		}
+		docsBase = ""
+	} else if e.Docs != "" {
+		if docsBase, err = NormalizeDocsBase(e.Docs); err != nil {
+			return nil, err
+		}
+	}
+
+	headers, err := deprecationHeaders(&e)
+	if err != nil {
+		return nil, err
+	}
+
+	if (e.SourceDir == "") != (e.SourceFile == "") {
+		return nil, errors.New("source dir and file must be given together")
+	}
+
+	r := &Redirect{
+		opts:       opts,
+		wildcard:   wildcard,
+		deep:       deep,
+		importPath: importPath,
+		hostSuffix: hostSuffix,
+		repo:       repo,
+		repoElem:   repoElem,
+		depth:      e.Depth,
+		vcs:        vcs,
+		ref:        ref,
+		docsBase:   docsBase,
+		sourceDir:  e.SourceDir,
+		sourceFile: e.SourceFile,
+		message:    e.Message,
+		headers:    headers,
+	}
+	return r, nil
+}
+
+// NewHandler returns a handler serving entries and their routes with opts, dispatching each request
+// to the entry or route with the longest matching import path. If opts is nil, the zero Options are
+// used.
+func NewHandler(entries []Entry, opts *Options) (http.Handler, error) {
+	redirects := make([]*Redirect, 0, len(entries))
+	for _, e := range entries {
+		rs, err := NewRedirects(e, opts)
+		if err != nil {
+			return nil, fmt.Errorf("error creating redirect %s -> %s: %v", e.ImportPath, e.Repo, err)
+		}
+		redirects = append(redirects, rs...)
+	}
+	return NewRouter(redirects, opts)
+}
+
+// NormalizeDocsBase checks that base is a full URL and returns it with a trailing slash, so that
+// import paths can be appended to it.
+func NormalizeDocsBase(base string) (string, error) {
+	if u, err := url.Parse(base); err != nil || !u.IsAbs() {
+		return "", fmt.Errorf("docs base %q must be a full URL", base)
+	}
+	return strings.TrimSuffix(base, "/") + "/", nil
+}
+
+// deprecationHeaders returns the headers announcing the deprecation of e, or nil if it isn't
+// deprecated. The Deprecation header holds the date as a structured field (RFC 9745), and the
+// Sunset header an HTTP date.
+func deprecationHeaders(e *Entry) (http.Header, error) {
+	if e.Deprecated.IsZero() {
+		if !e.Sunset.IsZero() || e.Successor != "" {
+			return nil, errors.New("sunset and successor require a deprecation date")
+		}
+		return nil, nil
+	}
+	h := http.Header{"Deprecation": {"@" + strconv.FormatInt(e.Deprecated.Unix(), 10)}}
+	if !e.Sunset.IsZero() {
+		if e.Sunset.Before(e.Deprecated) {
+			return nil, fmt.Errorf("sunset %s is before deprecation %s", e.Sunset.Format(time.RFC3339), e.Deprecated.Format(time.RFC3339))
+		}
+		h.Set("Sunset", e.Sunset.UTC().Format(http.TimeFormat))
+	}
+	if e.Successor != "" {
+		u, err := url.Parse(e.Successor)
+		if err != nil || !u.IsAbs() || u.Host == "" {
+			return nil, fmt.Errorf("successor %q must be a full URL", e.Successor)
+		}
+		h.Set("Link", "<"+u.String()+`>; rel="successor-version"`)
+	}
+	return h, nil
+}
+
+// ImportPath returns the import path of r, without any trailing /*.
+func (r *Redirect) ImportPath() string {
+	return r.importPath
+}
+
+// Wildcard returns whether r serves every import path under its import path.
+func (r *Redirect) Wildcard() bool {
+	return r.wildcard
+}
+
+// Subdomains returns whether r serves its import path on each subdomain of a domain, as given by an
+// import path beginning with *.
+func (r *Redirect) Subdomains() bool {
+	return r.hostSuffix != ""
+}
+
+// Repo returns the repository URL of r, without any VCS prefix, trailing /*, or ref. Any {elem}
+// placeholder is left in its path.
+func (r *Redirect) Repo() *url.URL {
+	u := *r.repo
+	return &u
+}
+
+// VCS returns the version control system of r.
+func (r *Redirect) VCS() string {
+	return r.vcs
+}
+
+// repoPattern returns the repository URL of r as given, with any {elem} placeholder unescaped.
+func (r *Redirect) repoPattern() string {
+	if r.repoElem {
+		return strings.Replace(r.repo.String(), "%7Belem%7D", "{elem}", -1)
+	}
+	return r.repo.String()
+}
+
+// Patterns returns the import path and repository URL of r as they would be given on the command
+// line, including any trailing /* or /** and {elem} placeholder, but without a VCS prefix or ref.
+func (r *Redirect) Patterns() (importPath, repo string) {
+	importPath, repo = r.importPath, r.repoPattern()
+	suffix := ""
+	switch {
+	case r.deep:
+		suffix = "/**"
+	case r.wildcard:
+		suffix = "/*"
+	}
+	if r.repoElem {
+		return importPath + suffix, repo
+	}
+	return importPath + suffix, repo + suffix
+}
+
+// onHost returns the import path and repository URL of r for a request on host, with the label of
+// host substituted for the * of a subdomain wildcard. It reports false if r doesn't serve host,
+// which for a subdomain wildcard must be a single label under its domain; the domain itself isn't
+// served.
+func (r *Redirect) onHost(host string) (importPath string, repo url.URL, ok bool) {
+	repo = *r.repo
+	if r.hostSuffix == "" {
+		return r.importPath, repo, true
+	}
+	label := strings.TrimSuffix(host, r.hostSuffix)
+	if len(label) == len(host) || label == "" || strings.Contains(label, ".") {
+		return "", repo, false
+	}
+	repo.Path = strings.Replace(repo.Path, "*", label, 1)
+	return label + r.importPath[1:], repo, true
+}
+
+// A RouteRecorder is a ResponseWriter that records the import root and VCS root that a request
+// resolved to, such as for an access log. A Redirect calls RecordRoute on any ResponseWriter that
+// implements it.
+type RouteRecorder interface {
+	RecordRoute(importRoot, vcsRoot string)
+}
+
+// A PatternRecorder is a ResponseWriter that records the import path of the redirect serving a
+// request as it was given, including any wildcard, such as for metrics that must not grow with
+// the number of distinct paths requested. A Redirect calls RecordPattern on any ResponseWriter
+// that implements it, before responding.
+type PatternRecorder interface {
+	RecordPattern(importPath string)
+}
+
+func (r *Redirect) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	opts := r.opts
+	if opts.StrictMethods && !checkMethod(w, req) {
+		return
+	}
+
+	if limit := opts.MaxPathLen; limit > 0 && len(req.URL.Path) > limit {
+		http.Error(w, "request path too long", http.StatusRequestURITooLong)
+		return
+	}
+
+	if opts.StrictQuery && !isGoGetQuery(req.URL.RawQuery) {
+		http.Error(w, "unexpected query parameters", http.StatusBadRequest)
+		return
+	}
+
+	// The path is cleaned so that the import root and suffix are the same with or without trailing
+	// or repeated slashes, even when r is used without a Router to clean it first.
+	host := requestHost(req)
+	reqPath := strings.TrimSuffix(host+path.Clean("/"+req.URL.Path), "/")
+	importPath, repo, ok := r.onHost(host)
+	if !ok {
+		opts.notFound(w, req)
+		return
+	}
+	if pr, ok := w.(PatternRecorder); ok {
+		pattern, _ := r.Patterns()
+		pr.RecordPattern(pattern)
+	}
+	for name, values := range r.headers {
+		w.Header()[name] = values
+	}
+	var importRoot, repoRoot, suffix, elem string
+	ref := r.ref
+	if r.wildcard {
+		if reqPath == importPath {
+			r.serveRoot(w, req, importPath)
+			return
+		}
+		if !strings.HasPrefix(reqPath, importPath+"/") {
+			opts.notFound(w, req)
+			return
+		}
+		if r.deep {
+			elem = reqPath[len(importPath)+1:]
+			if opts.MajorRoots {
+				elem, suffix = splitDeepMajorVersion(elem)
+			}
+		} else {
+			depth := r.depth
+			if depth < 1 {
+				depth = opts.WildcardDepth
+			}
+			if depth < 1 {
+				depth = 1
+			}
+			elem, suffix, ok = splitElems(reqPath[len(importPath)+1:], depth)
+			if !ok {
+				opts.notFound(w, req)
+				return
+			}
+		}
+
+		importRoot = path.Join(importPath, elem)
+		if opts.GopkgVersions {
+			if name, version, ok := splitGopkgVersion(elem); ok {
+				elem, ref = name, version
+			}
+		}
+		if r.repoElem {
+			repo.Path = strings.Replace(repo.Path, "{elem}", elem, -1)
+		} else {
+			repo.Path = path.Join(repo.Path, elem)
+		}
+		repoRoot = repo.String()
+		if opts.MajorRoots {
+			var major string
+			major, suffix = splitMajorVersion(suffix)
+			importRoot += major
+		}
+		opts.debugf(req, "wildcard %s/: elem=%q vcs-root=%q suffix=%q", importPath, elem, repoRoot, suffix)
+	} else {
+		if reqPath != importPath && !strings.HasPrefix(reqPath, importPath+"/") {
+			opts.notFound(w, req)
+			return
+		}
+		importRoot = importPath
+		repoRoot = repo.String()
+		suffix = reqPath[len(importPath):]
+		if opts.MajorRoots {
+			var major string
+			major, suffix = splitMajorVersion(suffix)
+			importRoot += major
+		}
+	}
+	d := &Data{
+		ImportRoot: importRoot,
+		VCS:        r.vcs,
+		VCSRoot:    repoRoot,
+		Ref:        ref,
+		Suffix:     suffix,
+		DocsBase:   r.docsBase,
+		Refresh:    !opts.ManualRedirect,
+		Message:    r.message,
+	}
+	if rr, ok := w.(RouteRecorder); ok {
+		rr.RecordRoute(importRoot, repoRoot)
+	}
+	if r.sourceDir != "" {
+		rep := strings.NewReplacer("{elem}", elem, "{ref}", ref)
+		d.GoSource = strings.Join([]string{
+			importRoot,
+			repoRoot,
+			rep.Replace(r.sourceDir),
+			rep.Replace(r.sourceFile),
+		}, " ")
+	}
+	switch {
+	case r.docsBase == "":
+		// Documentation redirects are disabled.
+	case suffix == "" && opts.RootDocs == "repo":
+		d.DocsURL = repoRoot
+	default:
+		u, err := opts.docsURL(d)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		d.DocsURL = u
+	}
+	w.Header().Add("Vary", "Accept")
+	if acceptsJSON(req) {
+		opts.setCacheControl(w)
+		if err := respondJSON(w, d); err != nil {
+			opts.debugf(req, "error writing response for %s: %v", reqPath, err)
+		}
+		return
+	}
+	goGet := isGoGet(req)
+	if opts.StrictGoGet && !goGet {
+		target := d.DocsURL
+		if target == "" {
+			target = repoRoot
+		}
+		opts.setCacheControl(w)
+		http.Redirect(w, req, target, opts.redirectStatus())
+		return
+	}
+	if opts.Canonical {
+		d.Canonical = d.DocsURL
+	}
+	if opts.JSONLD {
+		ld, err := packageJSONLD(d)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		d.JSONLD = ld
+	}
+	t := opts.BrowserTemplate
+	if goGet {
+		t = opts.GoGetTemplate
+	}
+	if t == nil {
+		t = DefaultTemplate
+	}
+	var buf bytes.Buffer
+	err := t.Execute(&buf, d)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	body := buf.Bytes()
+	if opts.Minify {
+		body = minifyHTML(body)
+	}
+	opts.setCacheControl(w)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if _, err := w.Write(body); err != nil {
+		// The client most likely went away; the response can't be completed, so give up.
+		opts.debugf(req, "error writing response for %s: %v", reqPath, err)
+	}
+}
+
+// serveRoot responds to a request for the bare root of a wildcard import path, importPath,
+// according to the WildcardRoot option.
+func (r *Redirect) serveRoot(w http.ResponseWriter, req *http.Request, importPath string) {
+	opts := r.opts
+	switch action := opts.WildcardRoot; action {
+	case "", "docs":
+		if r.docsBase == "" {
+			opts.notFound(w, req)
+			return
+		}
+		u, err := opts.docsURL(&Data{
+			ImportRoot: importPath,
+			VCS:        r.vcs,
+			VCSRoot:    r.repoPattern(),
+			DocsBase:   r.docsBase,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		opts.setCacheControl(w)
+		http.Redirect(w, req, u, opts.redirectStatus())
+	case "404":
+		opts.notFound(w, req)
+	case "204":
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		opts.setCacheControl(w)
+		http.Redirect(w, req, action, opts.redirectStatus())
+	}
+}
+
+// splitElems splits p after its first n path elements, returning those elements and the remainder
+// of p, which begins with a slash if non-empty. It reports false if p has fewer than n elements.
+func splitElems(p string, n int) (elems, rest string, ok bool) {
+	end := 0
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			end++ // Skip the slash.
+		}
+		j := strings.IndexByte(p[end:], '/')
+		if j < 0 {
+			if i < n-1 {
+				return "", "", false
+			}
+			return p, "", true
+		}
+		end += j
+	}
+	return p[:end], p[end:], true
+}
+
+// splitMajorVersion splits a major version element, such as /v2, from the start of suffix and
+// returns it and the remainder of suffix. Only versions 2 and up, without leading zeroes, are
+// split. If suffix does not begin with a major version, it returns "" and suffix.
+func splitMajorVersion(suffix string) (major, rest string) {
+	elem, rest, _ := splitElems(strings.TrimPrefix(suffix, "/"), 1)
+	if !strings.HasPrefix(suffix, "/") || !strings.HasPrefix(elem, "v") {
+		return "", suffix
+	}
+	n, err := strconv.Atoi(elem[1:])
+	if err != nil || n < 2 || strconv.Itoa(n) != elem[1:] {
+		return "", suffix
+	}
+	return "/" + elem, rest
+}
+
+// splitGopkgVersion splits a gopkg.in-style version, such as the .v2 of group/pkg.v2, from the
+// last element of elems and returns elems without it and the version without its dot. If the last
+// element has no such version, ok is false.
+func splitGopkgVersion(elems string) (name, version string, ok bool) {
+	i := strings.LastIndex(elems, ".v")
+	if i <= 0 || elems[i-1] == '/' || strings.IndexByte(elems[i:], '/') >= 0 {
+		return elems, "", false
+	}
+	n, err := strconv.Atoi(elems[i+2:])
+	if err != nil || n < 0 || strconv.Itoa(n) != elems[i+2:] {
+		return elems, "", false
+	}
+	return elems[:i], elems[i+1:], true
+}
+
+// splitDeepMajorVersion splits the path elems taken by a deep wildcard before its first major
+// version element after the first, such as the /v2 of group/project/v2/pkg, so that the major
+// version begins the returned suffix. If elems has no major version element, it returns elems and
+// "".
+func splitDeepMajorVersion(elems string) (repoElems, suffix string) {
+	for i := strings.IndexByte(elems, '/'); i >= 0; {
+		if major, _ := splitMajorVersion(elems[i:]); major != "" {
+			return elems[:i], elems[i:]
+		}
+		j := strings.IndexByte(elems[i+1:], '/')
+		if j < 0 {
+			break
+		}
+		i += j + 1
+	}
+	return elems, ""
+}
+
+// jsonResponse is the response to a request for JSON.
+type jsonResponse struct {
+	ImportRoot string `json:"import_root"`
+	VCS        string `json:"vcs"`
+	VCSRoot    string `json:"vcs_root"`
+	Suffix     string `json:"suffix"`
+	DocsURL    string `json:"docs_url"`
+}
+
+// acceptsJSON returns whether req prefers application/json to HTML in its Accept header. JSON must
+// be listed by name with a quality above zero and above that of HTML, which is given by text/html,
+// or else text/* or */*; a tie goes to HTML.
+func acceptsJSON(req *http.Request) bool {
+	jsonQ := -1.0
+	var htmlQ [3]float64 // qualities for text/html, text/*, and */*, by specificity
+	for i := range htmlQ {
+		htmlQ[i] = -1
+	}
+	for _, accept := range req.Header["Accept"] {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(mediaRange)
+			if err != nil {
+				continue
+			}
+			q := 1.0
+			if v, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(v, 64); err != nil || q < 0 || q > 1 {
+					continue
+				}
+			}
+			switch mediaType {
+			case "application/json":
+				jsonQ = math.Max(jsonQ, q)
+			case "text/html":
+				htmlQ[0] = math.Max(htmlQ[0], q)
+			case "text/*":
+				htmlQ[1] = math.Max(htmlQ[1], q)
+			case "*/*":
+				htmlQ[2] = math.Max(htmlQ[2], q)
+			}
+		}
+	}
+	html := 0.0
+	for _, q := range htmlQ {
+		if q >= 0 {
+			html = q
+			break
+		}
+	}
+	return jsonQ > 0 && jsonQ > html
+}
+
+// respondJSON writes the redirect described by d to w as JSON.
+func respondJSON(w http.ResponseWriter, d *Data) error {
+	body, err := json.Marshal(&jsonResponse{
+		ImportRoot: d.ImportRoot,
+		VCS:        d.VCS,
+		VCSRoot:    d.VCSRoot,
+		Suffix:     d.Suffix,
+		DocsURL:    d.DocsURL,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+	body = append(body, '\n')
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	_, err = w.Write(body)
+	return err
+}
+
+// checkMethod returns whether req uses GET or HEAD. If it does not, checkMethod writes a 405 or
+// 501 response to w.
+func checkMethod(w http.ResponseWriter, req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		return true
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+		http.MethodOptions, http.MethodTrace, http.MethodConnect:
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	default:
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+	}
+	return false
+}
+
+// isGoGet returns whether req is from go get, which sets the query parameter go-get=1.
+func isGoGet(req *http.Request) bool {
+	return req.URL.Query().Get("go-get") == "1"
+}
+
+// isGoGetQuery returns whether rawQuery is either empty or contains only go-get=1.
+func isGoGetQuery(rawQuery string) bool {
+	if rawQuery == "" {
+		return true
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil || len(query) != 1 {
+		return false
+	}
+	goGet := query["go-get"]
+	return len(goGet) == 1 && goGet[0] == "1"
+}
+
+// Hostname returns host with any port removed. Brackets around an IPv6 address are also removed,
+// with or without a port, so that "[::1]:9001" and "[::1]" both become "::1".
+func Hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return host[1 : len(host)-1]
+	}
+	return host
+}
+
+// requestHost returns the host of req, without any port, in lower case. Hosts are matched without
+// regard to case, as in DNS, while the rest of an import path is matched exactly.
+func requestHost(req *http.Request) string {
+	return strings.ToLower(Hostname(req.Host))
+}
@@ -0,0 +1,1379 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package redirector_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	texttemplate "text/template"
+	"time"
+
+	"go.spiff.io/go-import-redirector/redirector"
+	"golang.org/x/net/html"
+)
+
+var (
+	goImportRE = regexp.MustCompile(`<meta name="go-import" content="([^"]*)">`)
+	refreshRE  = regexp.MustCompile(`<meta http-equiv="refresh" content="0; url=([^"]*)">`)
+)
+
+// pkgGoDev is the Options used by most tests, which redirect browsers to pkg.go.dev.
+func pkgGoDev() *redirector.Options {
+	return &redirector.Options{DocsBase: "https://pkg.go.dev/"}
+}
+
+// newHandler returns a handler for the given import path and repo pairs with opts.
+func newHandler(t *testing.T, opts *redirector.Options, pairs ...string) http.Handler {
+	t.Helper()
+	entries := make([]redirector.Entry, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		entries = append(entries, redirector.Entry{ImportPath: pairs[i], Repo: pairs[i+1]})
+	}
+	h, err := redirector.NewHandler(entries, opts)
+	if err != nil {
+		t.Fatalf("NewHandler(%q) failed: %v", pairs, err)
+	}
+	return h
+}
+
+// do serves a request for target, a URL without its scheme such as rsc.io/x86?go-get=1, with h.
+// The header is given as alternating names and values.
+func do(h http.Handler, method, target string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "http://"+target, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// get serves a GET request for target with h.
+func get(h http.Handler, target string, header ...string) *httptest.ResponseRecorder {
+	return do(h, http.MethodGet, target, header...)
+}
+
+// goImport returns the content of the go-import meta tag in body, or "" if there is none.
+func goImport(body string) string {
+	if m := goImportRE.FindStringSubmatch(body); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// refresh returns the URL of the refresh meta tag in body, or "" if there is none.
+func refresh(body string) string {
+	if m := refreshRE.FindStringSubmatch(body); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+func TestNewHandler(t *testing.T) {
+	h := newHandler(t, pkgGoDev(),
+		"9fans.net/go", "https://github.com/9fans/go",
+		"rsc.io/*", "https://github.com/rsc/*",
+	)
+	tests := []struct {
+		target   string
+		goImport string
+		refresh  string
+	}{
+		{
+			target:   "9fans.net/go",
+			goImport: "9fans.net/go git https://github.com/9fans/go",
+			refresh:  "https://pkg.go.dev/9fans.net/go",
+		},
+		{
+			target:   "9fans.net/go/acme/editinacme?go-get=1",
+			goImport: "9fans.net/go git https://github.com/9fans/go",
+			refresh:  "https://pkg.go.dev/9fans.net/go/acme/editinacme",
+		},
+		{
+			target:   "rsc.io/x86/x86asm?go-get=1",
+			goImport: "rsc.io/x86 git https://github.com/rsc/x86",
+			refresh:  "https://pkg.go.dev/rsc.io/x86/x86asm",
+		},
+		{
+			target:   "rsc.io/pdf",
+			goImport: "rsc.io/pdf git https://github.com/rsc/pdf",
+			refresh:  "https://pkg.go.dev/rsc.io/pdf",
+		},
+	}
+	for _, tt := range tests {
+		w := get(h, tt.target)
+		if w.Code != http.StatusOK {
+			t.Errorf("GET %s: status = %d, want %d", tt.target, w.Code, http.StatusOK)
+			continue
+		}
+		body := w.Body.String()
+		if got := goImport(body); got != tt.goImport {
+			t.Errorf("GET %s: go-import = %q, want %q", tt.target, got, tt.goImport)
+		}
+		if got := refresh(body); got != tt.refresh {
+			t.Errorf("GET %s: refresh = %q, want %q", tt.target, got, tt.refresh)
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+			t.Errorf("GET %s: Content-Type = %q, want text/html", tt.target, ct)
+		}
+	}
+}
+
+func TestNewHandlerNotFound(t *testing.T) {
+	h := newHandler(t, pkgGoDev(),
+		"9fans.net/go", "https://github.com/9fans/go",
+		"rsc.io/*", "https://github.com/rsc/*",
+	)
+	for _, target := range []string{
+		"example.com/",
+		"9fans.net/",
+		"9fans.net/gopher",
+		"golang.org/x/net",
+	} {
+		if w := get(h, target+"?go-get=1"); w.Code != http.StatusNotFound {
+			t.Errorf("GET %s: status = %d, want %d", target, w.Code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestNewHandlerNoDocs(t *testing.T) {
+	h := newHandler(t, nil, "9fans.net/go", "https://github.com/9fans/go")
+	body := get(h, "9fans.net/go/draw").Body.String()
+	if got, want := goImport(body), "9fans.net/go git https://github.com/9fans/go"; got != want {
+		t.Errorf("go-import = %q, want %q", got, want)
+	}
+	if got := refresh(body); got != "" {
+		t.Errorf("refresh = %q with no DocsBase, want none", got)
+	}
+}
+
+func TestNewRedirectErrors(t *testing.T) {
+	tests := []redirector.Entry{
+		{ImportPath: "", Repo: "https://github.com/rsc/pdf"},
+		{ImportPath: "rsc.io/pdf", Repo: "github.com/rsc/pdf"},
+		{ImportPath: "rsc.io/*", Repo: "https://github.com/rsc/pdf"},
+		{ImportPath: "rsc.io/pdf", Repo: "https://github.com/rsc/*"},
+		{ImportPath: "rsc.io/pdf", Repo: "cvs+https://example.com/rsc/pdf"},
+	}
+	for _, e := range tests {
+		if _, err := redirector.NewRedirect(e, nil); err == nil {
+			t.Errorf("NewRedirect(%+v) succeeded, want error", e)
+		}
+	}
+}
+
+func TestRedirectServeHTTP(t *testing.T) {
+	// A Redirect serves requests on its own, without a Router.
+	r, err := redirector.NewRedirect(redirector.Entry{ImportPath: "rsc.io/*", Repo: "https://github.com/rsc/*"}, pkgGoDev())
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := get(r, "rsc.io/pdf/pdfpasswd?go-get=1").Body.String()
+	if got, want := goImport(body), "rsc.io/pdf git https://github.com/rsc/pdf"; got != want {
+		t.Errorf("go-import = %q, want %q", got, want)
+	}
+	if w := get(r, "golang.org/x/net"); w.Code != http.StatusNotFound {
+		t.Errorf("GET golang.org/x/net: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestRenderMeta(t *testing.T) {
+	var buf bytes.Buffer
+	err := redirector.RenderMeta(&buf, &redirector.Data{
+		ImportRoot: "9fans.net/go",
+		VCS:        "git",
+		VCSRoot:    "https://github.com/9fans/go",
+		DocsURL:    "https://pkg.go.dev/9fans.net/go/draw",
+		Refresh:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := goImport(buf.String()), "9fans.net/go git https://github.com/9fans/go"; got != want {
+		t.Errorf("go-import = %q, want %q", got, want)
+	}
+	if got, want := refresh(buf.String()), "https://pkg.go.dev/9fans.net/go/draw"; got != want {
+		t.Errorf("refresh = %q, want %q", got, want)
+	}
+}
+
+func TestRootDocs(t *testing.T) {
+	tests := []struct {
+		rootDocs string
+		target   string
+		want     string
+	}{
+		{"", "9fans.net/go", "https://pkg.go.dev/9fans.net/go"},
+		{"pkg", "9fans.net/go", "https://pkg.go.dev/9fans.net/go"},
+		{"repo", "9fans.net/go", "https://github.com/9fans/go"},
+		// Subpaths always go to the package documentation.
+		{"pkg", "9fans.net/go/draw", "https://pkg.go.dev/9fans.net/go/draw"},
+		{"repo", "9fans.net/go/draw", "https://pkg.go.dev/9fans.net/go/draw"},
+		{"repo", "rsc.io/pdf", "https://github.com/rsc/pdf"},
+		{"repo", "rsc.io/pdf/pdfpasswd", "https://pkg.go.dev/rsc.io/pdf/pdfpasswd"},
+	}
+	for _, tt := range tests {
+		opts := pkgGoDev()
+		opts.RootDocs = tt.rootDocs
+		h := newHandler(t, opts,
+			"9fans.net/go", "https://github.com/9fans/go",
+			"rsc.io/*", "https://github.com/rsc/*",
+		)
+		body := get(h, tt.target).Body.String()
+		if got := refresh(body); got != tt.want {
+			t.Errorf("RootDocs %q: GET %s: refresh = %q, want %q", tt.rootDocs, tt.target, got, tt.want)
+		}
+		if got := goImport(body); got == "" {
+			t.Errorf("RootDocs %q: GET %s: no go-import meta tag", tt.rootDocs, tt.target)
+		}
+	}
+}
+
+func TestStrictQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		code  int
+	}{
+		{"", http.StatusOK},
+		{"?go-get=1", http.StatusOK},
+		{"?foo=bar", http.StatusBadRequest},
+		{"?go-get=1&foo=bar", http.StatusBadRequest},
+	}
+	for _, strict := range []bool{false, true} {
+		opts := pkgGoDev()
+		opts.StrictQuery = strict
+		h := newHandler(t, opts, "rsc.io/*", "https://github.com/rsc/*")
+		for _, tt := range tests {
+			want := tt.code
+			if !strict {
+				want = http.StatusOK
+			}
+			if w := get(h, "rsc.io/pdf"+tt.query); w.Code != want {
+				t.Errorf("StrictQuery %v: GET rsc.io/pdf%s: status = %d, want %d", strict, tt.query, w.Code, want)
+			}
+		}
+	}
+}
+
+func TestMaxPathLen(t *testing.T) {
+	opts := pkgGoDev()
+	opts.MaxPathLen = 32
+	h := newHandler(t, opts, "rsc.io/*", "https://github.com/rsc/*")
+	long := "/" + strings.Repeat("x", 40)
+	tests := []struct {
+		target string
+		code   int
+	}{
+		{"rsc.io/pdf", http.StatusOK},
+		{"rsc.io/pdf" + long, http.StatusRequestURITooLong},
+		// Paths matching no redirect are rejected before they are matched.
+		{"example.com" + long, http.StatusRequestURITooLong},
+		{"example.com/short", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if w := get(h, tt.target); w.Code != tt.code {
+			t.Errorf("GET %s: status = %d, want %d", tt.target, w.Code, tt.code)
+		}
+	}
+}
+
+func TestStrictMethods(t *testing.T) {
+	opts := pkgGoDev()
+	opts.StrictMethods = true
+	opts.HealthPath = "/healthz"
+	h := newHandler(t, opts, "rsc.io/*", "https://github.com/rsc/*")
+	tests := []struct {
+		method string
+		target string
+		code   int
+	}{
+		{http.MethodGet, "rsc.io/pdf", http.StatusOK},
+		{http.MethodHead, "rsc.io/pdf", http.StatusOK},
+		{http.MethodTrace, "rsc.io/pdf", http.StatusMethodNotAllowed},
+		{http.MethodConnect, "rsc.io/pdf", http.StatusMethodNotAllowed},
+		{http.MethodPost, "rsc.io/pdf", http.StatusMethodNotAllowed},
+		{"BREW", "rsc.io/pdf", http.StatusNotImplemented},
+		// Requests matching no redirect are checked too.
+		{http.MethodTrace, "example.com/pkg", http.StatusMethodNotAllowed},
+		{http.MethodPost, "example.com/healthz", http.StatusMethodNotAllowed},
+		{http.MethodGet, "example.com/healthz", http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := do(h, tt.method, tt.target)
+		if w.Code != tt.code {
+			t.Errorf("%s %s: status = %d, want %d", tt.method, tt.target, w.Code, tt.code)
+		}
+		if tt.code == http.StatusMethodNotAllowed && w.Header().Get("Allow") != "GET, HEAD" {
+			t.Errorf("%s %s: Allow = %q, want %q", tt.method, tt.target, w.Header().Get("Allow"), "GET, HEAD")
+		}
+	}
+
+	// Without StrictMethods, any method is served.
+	h = newHandler(t, pkgGoDev(), "rsc.io/*", "https://github.com/rsc/*")
+	if w := do(h, http.MethodTrace, "rsc.io/pdf"); w.Code != http.StatusOK {
+		t.Errorf("TRACE rsc.io/pdf without StrictMethods: status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestPageTemplates(t *testing.T) {
+	opts := pkgGoDev()
+	opts.GoGetTemplate = template.Must(template.New("go-get").Parse(`go-get {{.ImportRoot}}`))
+	opts.BrowserTemplate = template.Must(template.New("browser").Parse(`browser {{.DocsURL}}`))
+	h := newHandler(t, opts, "rsc.io/*", "https://github.com/rsc/*")
+	tests := []struct {
+		target string
+		body   string
+	}{
+		{"rsc.io/pdf?go-get=1", "go-get rsc.io/pdf"},
+		{"rsc.io/pdf", "browser https://pkg.go.dev/rsc.io/pdf"},
+	}
+	for _, tt := range tests {
+		if body := get(h, tt.target).Body.String(); body != tt.body {
+			t.Errorf("GET %s: body = %q, want %q", tt.target, body, tt.body)
+		}
+	}
+}
+
+// closingWriter is a ResponseWriter for a client that goes away after n bytes of the body.
+type closingWriter struct {
+	*httptest.ResponseRecorder
+	n      int
+	writes int
+}
+
+func (w *closingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	if len(p) > w.n {
+		n, _ := w.ResponseRecorder.Write(p[:w.n])
+		w.n = 0
+		return n, io.ErrClosedPipe
+	}
+	w.n -= len(p)
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestShortWrite(t *testing.T) {
+	var logged []string
+	opts := pkgGoDev()
+	opts.Debugf = func(req *http.Request, format string, args ...interface{}) {
+		logged = append(logged, fmt.Sprintf(format, args...))
+	}
+	h := newHandler(t, opts, "rsc.io/*", "https://github.com/rsc/*")
+
+	w := &closingWriter{ResponseRecorder: httptest.NewRecorder(), n: 16}
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://rsc.io/pdf?go-get=1", nil))
+	if w.writes != 1 {
+		t.Errorf("body written in %d calls, want 1", w.writes)
+	}
+	if w.Body.Len() != 16 {
+		t.Errorf("wrote %d bytes of the body, want 16", w.Body.Len())
+	}
+	if cl := w.Header().Get("Content-Length"); cl == "" || cl == "16" {
+		t.Errorf("Content-Length = %q, want the length of the full body", cl)
+	}
+	if n := len(logged); n == 0 || !strings.Contains(logged[n-1], io.ErrClosedPipe.Error()) {
+		t.Errorf("debug log = %q, want a message with the write error", logged)
+	}
+}
+
+func TestWildcardDepth(t *testing.T) {
+	opts := pkgGoDev()
+	opts.WildcardDepth = 2
+	h := newHandler(t, opts, "example.com/*", "https://gitlab.com/*")
+	tests := []struct {
+		target string
+		code   int
+		meta   string
+	}{
+		{"example.com/a/b?go-get=1", http.StatusOK, "example.com/a/b git https://gitlab.com/a/b"},
+		{"example.com/a/b/pkg?go-get=1", http.StatusOK, "example.com/a/b git https://gitlab.com/a/b"},
+		{"example.com/a/b/pkg/sub?go-get=1", http.StatusOK, "example.com/a/b git https://gitlab.com/a/b"},
+		{"example.com/a?go-get=1", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		w := get(h, tt.target)
+		if w.Code != tt.code {
+			t.Errorf("GET %s: status = %d, want %d", tt.target, w.Code, tt.code)
+			continue
+		}
+		if meta := goImport(w.Body.String()); meta != tt.meta {
+			t.Errorf("GET %s: go-import = %q, want %q", tt.target, meta, tt.meta)
+		}
+	}
+	if loc := refresh(get(h, "example.com/a/b/pkg").Body.String()); loc != "https://pkg.go.dev/example.com/a/b/pkg" {
+		t.Errorf("GET example.com/a/b/pkg: refresh = %q, want %q", loc, "https://pkg.go.dev/example.com/a/b/pkg")
+	}
+}
+
+func TestBareRoot(t *testing.T) {
+	h := newHandler(t, pkgGoDev(), "example.com/mod", "https://github.com/example/mod")
+	for _, target := range []string{"example.com/mod", "example.com/mod/"} {
+		body := get(h, target+"?go-get=1").Body.String()
+		if meta, want := goImport(body), "example.com/mod git https://github.com/example/mod"; meta != want {
+			t.Errorf("GET %s: go-import = %q, want %q", target, meta, want)
+		}
+		body = get(h, target).Body.String()
+		if loc, want := refresh(body), "https://pkg.go.dev/example.com/mod"; loc != want {
+			t.Errorf("GET %s: refresh = %q, want %q", target, loc, want)
+		}
+	}
+
+	opts := pkgGoDev()
+	opts.BrowserTemplate = template.Must(template.New("").Parse(`{{.ImportRoot}} [{{.Suffix}}] {{.DocsURL}}`))
+	h = newHandler(t, opts, "example.com/mod", "https://github.com/example/mod")
+	want := "example.com/mod [] https://pkg.go.dev/example.com/mod"
+	if body := get(h, "example.com/mod").Body.String(); body != want {
+		t.Errorf("GET example.com/mod: body = %q, want %q", body, want)
+	}
+}
+
+func TestManualRedirect(t *testing.T) {
+	opts := pkgGoDev()
+	opts.ManualRedirect = true
+	h := newHandler(t, opts, "rsc.io/*", "https://github.com/rsc/*")
+	body := get(h, "rsc.io/pdf").Body.String()
+	if loc := refresh(body); loc != "" {
+		t.Errorf("GET rsc.io/pdf: refresh = %q, want none", loc)
+	}
+	if link := `<a href="https://pkg.go.dev/rsc.io/pdf">`; !strings.Contains(body, link) {
+		t.Errorf("GET rsc.io/pdf: body %q does not contain %s", body, link)
+	}
+	if meta, want := goImport(body), "rsc.io/pdf git https://github.com/rsc/pdf"; meta != want {
+		t.Errorf("GET rsc.io/pdf: go-import = %q, want %q", meta, want)
+	}
+}
+
+func TestDocsBase(t *testing.T) {
+	tests := []struct {
+		base string
+		want string
+	}{
+		{"https://godoc.org/", "https://godoc.org/rsc.io/pdf/sub"},
+		{"https://pkg.go.dev", "https://pkg.go.dev/rsc.io/pdf/sub"},
+		{"https://docs.internal", "https://docs.internal/rsc.io/pdf/sub"},
+		{"https://docs.internal/go/", "https://docs.internal/go/rsc.io/pdf/sub"},
+	}
+	for _, tt := range tests {
+		base, err := redirector.NormalizeDocsBase(tt.base)
+		if err != nil {
+			t.Errorf("NormalizeDocsBase(%q) failed: %v", tt.base, err)
+			continue
+		}
+		h := newHandler(t, &redirector.Options{DocsBase: base}, "rsc.io/*", "https://github.com/rsc/*")
+		body := get(h, "rsc.io/pdf/sub").Body.String()
+		if loc := refresh(body); loc != tt.want {
+			t.Errorf("docs base %q: refresh = %q, want %q", tt.base, loc, tt.want)
+		}
+		if link := `<a href="` + tt.want + `">`; !strings.Contains(body, link) {
+			t.Errorf("docs base %q: body %q does not contain %s", tt.base, body, link)
+		}
+	}
+
+	for _, bad := range []string{"docs.internal", "/docs", "://"} {
+		if _, err := redirector.NormalizeDocsBase(bad); err == nil {
+			t.Errorf("NormalizeDocsBase(%q) succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestStrictGoGet(t *testing.T) {
+	opts := pkgGoDev()
+	opts.StrictGoGet = true
+	h := newHandler(t, opts,
+		"rsc.io/*", "https://github.com/rsc/*",
+		"9fans.net/go", "https://github.com/9fans/go")
+	tests := []struct {
+		target string
+		meta   string
+		loc    string
+	}{
+		{"rsc.io/pdf/sub?go-get=1", "rsc.io/pdf git https://github.com/rsc/pdf", ""},
+		{"rsc.io/pdf/sub", "", "https://pkg.go.dev/rsc.io/pdf/sub"},
+		{"9fans.net/go/draw?go-get=1", "9fans.net/go git https://github.com/9fans/go", ""},
+		{"9fans.net/go/draw", "", "https://pkg.go.dev/9fans.net/go/draw"},
+		{"9fans.net/go?go-get=0", "", "https://pkg.go.dev/9fans.net/go"},
+	}
+	for _, tt := range tests {
+		w := get(h, tt.target)
+		if tt.loc == "" {
+			if meta := goImport(w.Body.String()); w.Code != http.StatusOK || meta != tt.meta {
+				t.Errorf("GET %s = %d with go-import %q, want %d with %q", tt.target, w.Code, meta, http.StatusOK, tt.meta)
+			}
+			continue
+		}
+		if loc := w.Header().Get("Location"); w.Code != http.StatusFound || loc != tt.loc {
+			t.Errorf("GET %s = %d to %q, want %d to %q", tt.target, w.Code, loc, http.StatusFound, tt.loc)
+		}
+	}
+
+	// Without docs, browsers are sent to the repo.
+	opts = &redirector.Options{StrictGoGet: true}
+	h = newHandler(t, opts, "rsc.io/*", "https://github.com/rsc/*")
+	if loc := get(h, "rsc.io/pdf").Header().Get("Location"); loc != "https://github.com/rsc/pdf" {
+		t.Errorf("GET rsc.io/pdf without docs: Location = %q, want %q", loc, "https://github.com/rsc/pdf")
+	}
+}
+
+func TestNewHandlerErrorMessage(t *testing.T) {
+	entries := []redirector.Entry{
+		{ImportPath: "rsc.io/pdf", Repo: "https://github.com/rsc/pdf"},
+		{ImportPath: "rsc.io/*", Repo: "https://github.com/rsc/pdf"},
+	}
+	_, err := redirector.NewHandler(entries, nil)
+	if err == nil {
+		t.Fatalf("NewHandler(%+v) succeeded, want error", entries)
+	}
+	for _, s := range []string{"rsc.io/*", "https://github.com/rsc/pdf"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("NewHandler error %q does not contain %q", err, s)
+		}
+	}
+}
+
+func TestMajorRoots(t *testing.T) {
+	opts := pkgGoDev()
+	opts.MajorRoots = true
+	h := newHandler(t, opts,
+		"example.com/*", "https://github.com/example/*",
+		"9fans.net/go", "https://github.com/9fans/go")
+	tests := []struct {
+		target string
+		meta   string
+		docs   string
+	}{
+		{"example.com/foo/v2/bar", "example.com/foo/v2 git https://github.com/example/foo", "https://pkg.go.dev/example.com/foo/v2/bar"},
+		{"example.com/foo/v3", "example.com/foo/v3 git https://github.com/example/foo", "https://pkg.go.dev/example.com/foo/v3"},
+		{"9fans.net/go/v2/draw", "9fans.net/go/v2 git https://github.com/9fans/go", "https://pkg.go.dev/9fans.net/go/v2/draw"},
+		// Elements that merely look like versions are packages.
+		{"example.com/foo/validator", "example.com/foo git https://github.com/example/foo", "https://pkg.go.dev/example.com/foo/validator"},
+		{"example.com/foo/v1", "example.com/foo git https://github.com/example/foo", "https://pkg.go.dev/example.com/foo/v1"},
+		{"example.com/foo/v02", "example.com/foo git https://github.com/example/foo", "https://pkg.go.dev/example.com/foo/v02"},
+		{"example.com/foo/v2x", "example.com/foo git https://github.com/example/foo", "https://pkg.go.dev/example.com/foo/v2x"},
+	}
+	for _, tt := range tests {
+		if meta := goImport(get(h, tt.target+"?go-get=1").Body.String()); meta != tt.meta {
+			t.Errorf("GET %s: go-import = %q, want %q", tt.target, meta, tt.meta)
+		}
+		if docs := refresh(get(h, tt.target).Body.String()); docs != tt.docs {
+			t.Errorf("GET %s: refresh = %q, want %q", tt.target, docs, tt.docs)
+		}
+	}
+
+	// Without MajorRoots, the version is part of the package path.
+	h = newHandler(t, pkgGoDev(), "example.com/*", "https://github.com/example/*")
+	if meta := goImport(get(h, "example.com/foo/v2/bar?go-get=1").Body.String()); meta != "example.com/foo git https://github.com/example/foo" {
+		t.Errorf("GET example.com/foo/v2/bar without MajorRoots: go-import = %q", meta)
+	}
+}
+
+func TestRef(t *testing.T) {
+	r, err := redirector.NewRedirect(redirector.Entry{
+		ImportPath: "rsc.io/*",
+		Repo:       "git+https://github.com/rsc/*#develop",
+		SourceDir:  "https://github.com/rsc/{elem}/tree/{ref}{/dir}",
+		SourceFile: "https://github.com/rsc/{elem}/blob/{ref}{/dir}/{file}#L{line}",
+	}, pkgGoDev())
+	if err != nil {
+		t.Fatalf("NewRedirect failed: %v", err)
+	}
+	if repo := r.Repo().String(); repo != "https://github.com/rsc" {
+		t.Errorf("Repo() = %q, want %q", repo, "https://github.com/rsc")
+	}
+	if _, repo := r.Patterns(); repo != "https://github.com/rsc/*" {
+		t.Errorf("Patterns() repo = %q, want %q", repo, "https://github.com/rsc/*")
+	}
+
+	w := get(r, "rsc.io/x86/x86asm?go-get=1")
+	body := w.Body.String()
+	// The ref is left out of the go-import tag, which has no field for it.
+	if meta, want := goImport(body), "rsc.io/x86 git https://github.com/rsc/x86"; meta != want {
+		t.Errorf("go-import = %q, want %q", meta, want)
+	}
+	source := `<meta name="go-source" content="rsc.io/x86 https://github.com/rsc/x86 ` +
+		`https://github.com/rsc/x86/tree/develop{/dir} https://github.com/rsc/x86/blob/develop{/dir}/{file}#L{line}">`
+	if !strings.Contains(body, source) {
+		t.Errorf("body %q does not contain %s", body, source)
+	}
+
+	opts := pkgGoDev()
+	opts.GoGetTemplate = template.Must(template.New("").Parse(`{{.ImportRoot}}@{{.Ref}}`))
+	h := newHandler(t, opts, "9fans.net/go", "https://github.com/9fans/go#v0.1.0")
+	if body := get(h, "9fans.net/go?go-get=1").Body.String(); body != "9fans.net/go@v0.1.0" {
+		t.Errorf("template with Ref = %q, want %q", body, "9fans.net/go@v0.1.0")
+	}
+}
+
+func TestPermanent(t *testing.T) {
+	for _, permanent := range []bool{false, true} {
+		want := http.StatusFound
+		if permanent {
+			want = http.StatusMovedPermanently
+		}
+		opts := pkgGoDev()
+		opts.Permanent = permanent
+		opts.StrictGoGet = true
+		h := newHandler(t, opts, "rsc.io/*", "https://github.com/rsc/*")
+		// Both the wildcard root and a browser request without go-get=1 are sent straight on.
+		for _, target := range []string{"rsc.io/", "rsc.io/pdf"} {
+			if w := get(h, target); w.Code != want {
+				t.Errorf("GET %s with Permanent %t: status = %d, want %d", target, permanent, w.Code, want)
+			}
+		}
+	}
+}
+
+func TestCacheMaxAge(t *testing.T) {
+	opts := pkgGoDev()
+	opts.CacheMaxAge = 90 * time.Minute
+	h := newHandler(t, opts, "rsc.io/*", "https://github.com/rsc/*")
+	for _, target := range []string{"rsc.io/pdf?go-get=1", "rsc.io/pdf", "rsc.io/"} {
+		if cc := get(h, target).Header().Get("Cache-Control"); cc != "public, max-age=5400" {
+			t.Errorf("GET %s: Cache-Control = %q, want %q", target, cc, "public, max-age=5400")
+		}
+	}
+	// Responses not describing a redirect aren't cached.
+	if cc := get(h, "example.com/pkg").Header().Get("Cache-Control"); cc != "" {
+		t.Errorf("GET example.com/pkg: Cache-Control = %q, want none", cc)
+	}
+
+	h = newHandler(t, pkgGoDev(), "rsc.io/*", "https://github.com/rsc/*")
+	for _, target := range []string{"rsc.io/pdf?go-get=1", "rsc.io/"} {
+		if cc := get(h, target).Header().Get("Cache-Control"); cc != "" {
+			t.Errorf("GET %s without CacheMaxAge: Cache-Control = %q, want none", target, cc)
+		}
+	}
+}
+
+func TestDeepWildcard(t *testing.T) {
+	h := newHandler(t, pkgGoDev(), "example.com/**", "https://gitlab.com/**")
+	tests := []struct {
+		target string
+		meta   string
+	}{
+		{"example.com/group/project", "example.com/group/project git https://gitlab.com/group/project"},
+		{"example.com/group/subgroup/project", "example.com/group/subgroup/project git https://gitlab.com/group/subgroup/project"},
+		// The whole path is the import root, even if it was meant as a package.
+		{"example.com/group/project/pkg", "example.com/group/project/pkg git https://gitlab.com/group/project/pkg"},
+		{"example.com/group/subgroup/pkg", "example.com/group/subgroup/pkg git https://gitlab.com/group/subgroup/pkg"},
+	}
+	for _, tt := range tests {
+		if meta := goImport(get(h, tt.target+"?go-get=1").Body.String()); meta != tt.meta {
+			t.Errorf("GET %s: go-import = %q, want %q", tt.target, meta, tt.meta)
+		}
+	}
+}
+
+func TestEntryDepth(t *testing.T) {
+	entries := []redirector.Entry{
+		{ImportPath: "example.com/*", Repo: "https://gitlab.com/*", Depth: 2},
+		{ImportPath: "example.org/*", Repo: "https://gitlab.com/org/*", Depth: 3},
+		{ImportPath: "example.net/*", Repo: "https://github.com/net/*"},
+	}
+	opts := pkgGoDev()
+	opts.WildcardDepth = 1
+	h, err := redirector.NewHandler(entries, opts)
+	if err != nil {
+		t.Fatalf("NewHandler failed: %v", err)
+	}
+	tests := []struct {
+		target string
+		meta   string
+	}{
+		{"example.com/group/project/pkg", "example.com/group/project git https://gitlab.com/group/project"},
+		{"example.org/a/b/project/pkg/sub", "example.org/a/b/project git https://gitlab.com/org/a/b/project"},
+		{"example.net/project/pkg", "example.net/project git https://github.com/net/project"},
+		{"example.com/group", ""},
+	}
+	for _, tt := range tests {
+		if meta := goImport(get(h, tt.target+"?go-get=1").Body.String()); meta != tt.meta {
+			t.Errorf("GET %s: go-import = %q, want %q", tt.target, meta, tt.meta)
+		}
+	}
+
+	for _, e := range []redirector.Entry{
+		{ImportPath: "example.com/pkg", Repo: "https://gitlab.com/pkg", Depth: 2},
+		{ImportPath: "example.com/**", Repo: "https://gitlab.com/**", Depth: 2},
+		{ImportPath: "example.com/*", Repo: "https://gitlab.com/*", Depth: -1},
+	} {
+		if _, err := redirector.NewRedirect(e, nil); err == nil {
+			t.Errorf("NewRedirect(%+v) succeeded, want error", e)
+		}
+	}
+}
+
+func TestRepoElem(t *testing.T) {
+	h := newHandler(t, pkgGoDev(),
+		"rsc.io/*", "https://github.com/rsc/{elem}/subdir",
+		"example.com/*", "https://git.example.com/{elem}.git",
+		"9fans.net/*", "https://github.com/9fans/*")
+	tests := []struct {
+		target string
+		meta   string
+	}{
+		{"rsc.io/pdf/sub", "rsc.io/pdf git https://github.com/rsc/pdf/subdir"},
+		{"example.com/tool", "example.com/tool git https://git.example.com/tool.git"},
+		// A trailing /* is the same as /{elem}.
+		{"9fans.net/go/draw", "9fans.net/go git https://github.com/9fans/go"},
+	}
+	for _, tt := range tests {
+		if meta := goImport(get(h, tt.target+"?go-get=1").Body.String()); meta != tt.meta {
+			t.Errorf("GET %s: go-import = %q, want %q", tt.target, meta, tt.meta)
+		}
+	}
+
+	for _, e := range []redirector.Entry{
+		{ImportPath: "rsc.io/pdf", Repo: "https://github.com/rsc/{elem}"},
+		{ImportPath: "rsc.io/*", Repo: "https://github.com/{elem}/*"},
+		{ImportPath: "rsc.io/*", Repo: "https://{elem}.example.com/rsc"},
+	} {
+		if _, err := redirector.NewRedirect(e, nil); err == nil {
+			t.Errorf("NewRedirect(%+v) succeeded, want error", e)
+		}
+	}
+}
+
+func TestModVCS(t *testing.T) {
+	h := newHandler(t, pkgGoDev(), "example.com/foo", "mod+https://proxy.example.com")
+	body := get(h, "example.com/foo?go-get=1").Body.String()
+	if meta, want := goImport(body), "example.com/foo mod https://proxy.example.com"; meta != want {
+		t.Errorf("go-import = %q, want %q", meta, want)
+	}
+	// Documentation is still served by the docs site.
+	if docs, want := refresh(get(h, "example.com/foo/bar").Body.String()), "https://pkg.go.dev/example.com/foo/bar"; docs != want {
+		t.Errorf("refresh = %q, want %q", docs, want)
+	}
+
+	opts := pkgGoDev()
+	opts.DefaultVCS = "mod"
+	r, err := redirector.NewRedirect(redirector.Entry{ImportPath: "example.com/*", Repo: "https://proxy.example.com/*"}, opts)
+	if err != nil {
+		t.Fatalf("NewRedirect with DefaultVCS mod failed: %v", err)
+	}
+	if r.VCS() != "mod" {
+		t.Errorf("VCS() = %q, want mod", r.VCS())
+	}
+	if meta, want := goImport(get(r, "example.com/bar?go-get=1").Body.String()), "example.com/bar mod https://proxy.example.com/bar"; meta != want {
+		t.Errorf("go-import with DefaultVCS mod = %q, want %q", meta, want)
+	}
+}
+
+func TestEntryDocs(t *testing.T) {
+	entries := []redirector.Entry{
+		{ImportPath: "example.com/public", Repo: "https://github.com/example/public"},
+		{ImportPath: "example.com/internal/*", Repo: "https://git.example.com/*", Docs: "https://docs.example.com"},
+	}
+	h, err := redirector.NewHandler(entries, pkgGoDev())
+	if err != nil {
+		t.Fatalf("NewHandler failed: %v", err)
+	}
+	tests := []struct {
+		target string
+		docs   string
+	}{
+		{"example.com/public/pkg", "https://pkg.go.dev/example.com/public/pkg"},
+		{"example.com/internal/tool/cmd", "https://docs.example.com/example.com/internal/tool/cmd"},
+	}
+	for _, tt := range tests {
+		if docs := refresh(get(h, tt.target).Body.String()); docs != tt.docs {
+			t.Errorf("GET %s: refresh = %q, want %q", tt.target, docs, tt.docs)
+		}
+	}
+
+	// An entry's docs are used even with no DocsBase.
+	h, err = redirector.NewHandler(entries, nil)
+	if err != nil {
+		t.Fatalf("NewHandler failed: %v", err)
+	}
+	if docs := refresh(get(h, "example.com/public/pkg").Body.String()); docs != "" {
+		t.Errorf("GET example.com/public/pkg without DocsBase: refresh = %q, want none", docs)
+	}
+	if docs, want := refresh(get(h, "example.com/internal/tool").Body.String()), "https://docs.example.com/example.com/internal/tool"; docs != want {
+		t.Errorf("GET example.com/internal/tool without DocsBase: refresh = %q, want %q", docs, want)
+	}
+
+	if _, err := redirector.NewRedirect(redirector.Entry{ImportPath: "rsc.io/pdf", Repo: "https://github.com/rsc/pdf", Docs: "docs.example.com"}, nil); err == nil {
+		t.Errorf("NewRedirect with docs that aren't a full URL succeeded, want error")
+	}
+}
+
+func TestNoDocs(t *testing.T) {
+	entries := []redirector.Entry{
+		{ImportPath: "example.com/public", Repo: "https://github.com/example/public"},
+		{ImportPath: "example.com/internal/*", Repo: "https://git.example.com/*", NoDocs: true,
+			Routes: []redirector.Route{{Path: "legacy", Repo: "https://hg.example.com/legacy", VCS: "hg"}}},
+	}
+	h, err := redirector.NewHandler(entries, pkgGoDev())
+	if err != nil {
+		t.Fatalf("NewHandler failed: %v", err)
+	}
+	tests := []struct {
+		target   string
+		goImport string
+		docs     string
+	}{
+		{"example.com/public/pkg", "example.com/public git https://github.com/example/public", "https://pkg.go.dev/example.com/public/pkg"},
+		{"example.com/internal/tool/cmd", "example.com/internal/tool git https://git.example.com/tool", ""},
+		{"example.com/internal/legacy", "example.com/internal/legacy hg https://hg.example.com/legacy", ""},
+	}
+	for _, tt := range tests {
+		w := get(h, tt.target)
+		if w.Code != http.StatusOK {
+			t.Errorf("GET %s: status = %d, want %d", tt.target, w.Code, http.StatusOK)
+			continue
+		}
+		body := w.Body.String()
+		if got := goImport(body); got != tt.goImport {
+			t.Errorf("GET %s: go-import = %q, want %q", tt.target, got, tt.goImport)
+		}
+		if docs := refresh(body); docs != tt.docs {
+			t.Errorf("GET %s: refresh = %q, want %q", tt.target, docs, tt.docs)
+		}
+		if tt.docs == "" && strings.Contains(body, "docs at") {
+			t.Errorf("GET %s: body %q links to docs", tt.target, body)
+		}
+	}
+
+	// With no docs to send it to, a bare wildcard root isn't found.
+	if w := get(h, "example.com/internal/"); w.Code != http.StatusNotFound {
+		t.Errorf("GET example.com/internal/: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	w := get(h, "example.com/internal/tool", "Accept", "application/json")
+	var got map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("error decoding %q: %v", w.Body.String(), err)
+	}
+	if got["docs_url"] != "" {
+		t.Errorf("GET example.com/internal/tool as JSON: docs_url = %q, want none", got["docs_url"])
+	}
+
+	e := redirector.Entry{ImportPath: "rsc.io/pdf", Repo: "https://github.com/rsc/pdf", Docs: "https://docs.example.com/", NoDocs: true}
+	if _, err := redirector.NewRedirect(e, nil); err == nil {
+		t.Errorf("NewRedirect with both docs and no docs succeeded, want error")
+	}
+}
+
+func TestMessage(t *testing.T) {
+	entries := []redirector.Entry{
+		{ImportPath: "example.com/new", Repo: "https://github.com/example/new"},
+		{ImportPath: "example.com/old", Repo: "https://github.com/example/old", Message: "In maintenance mode; see <README>."},
+	}
+	h, err := redirector.NewHandler(entries, pkgGoDev())
+	if err != nil {
+		t.Fatalf("NewHandler failed: %v", err)
+	}
+	tests := []struct {
+		target string
+		text   string
+	}{
+		{"example.com/new/pkg", "Redirecting to docs at"},
+		{"example.com/old/pkg", "<p>In maintenance mode; see &lt;README&gt;.</p>"},
+	}
+	for _, tt := range tests {
+		body := get(h, tt.target).Body.String()
+		if !strings.Contains(body, tt.text) {
+			t.Errorf("GET %s: body %q does not contain %q", tt.target, body, tt.text)
+		}
+		// The message only replaces the text; the meta tags and refresh are unchanged.
+		if meta, want := goImport(body), tt.target[:strings.LastIndex(tt.target, "/")]; !strings.HasPrefix(meta, want+" git ") {
+			t.Errorf("GET %s: go-import = %q, want one for %s", tt.target, meta, want)
+		}
+		if docs, want := refresh(body), "https://pkg.go.dev/"+tt.target; docs != want {
+			t.Errorf("GET %s: refresh = %q, want %q", tt.target, docs, want)
+		}
+	}
+	if body := get(h, "example.com/old/pkg").Body.String(); strings.Contains(body, "Redirecting to docs") {
+		t.Errorf("GET example.com/old/pkg: body %q has the standard text as well as the message", body)
+	}
+}
+
+func TestDeprecation(t *testing.T) {
+	deprecated := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	sunset := time.Date(2027, 1, 1, 12, 0, 0, 0, time.FixedZone("EST", -5*60*60))
+	entries := []redirector.Entry{
+		{ImportPath: "example.com/new", Repo: "https://github.com/example/new"},
+		{ImportPath: "example.com/old/*", Repo: "https://github.com/example/*",
+			Deprecated: deprecated, Sunset: sunset, Successor: "https://example.com/new",
+			Routes: []redirector.Route{{Path: "legacy", Repo: "https://hg.example.com/legacy", VCS: "hg"}}},
+		{ImportPath: "example.com/retiring", Repo: "https://github.com/example/retiring", Deprecated: deprecated},
+	}
+	h, err := redirector.NewHandler(entries, pkgGoDev())
+	if err != nil {
+		t.Fatalf("NewHandler failed: %v", err)
+	}
+	const (
+		deprecation = "@1780272000"
+		sunsetDate  = "Fri, 01 Jan 2027 17:00:00 GMT"
+		link        = `<https://example.com/new>; rel="successor-version"`
+	)
+	tests := []struct {
+		target      string
+		header      []string
+		code        int
+		deprecation string
+		sunset      string
+		link        string
+	}{
+		{target: "example.com/new/pkg?go-get=1", code: http.StatusOK},
+		{target: "example.com/old/pkg?go-get=1", code: http.StatusOK, deprecation: deprecation, sunset: sunsetDate, link: link},
+		{target: "example.com/old/pkg", code: http.StatusOK, deprecation: deprecation, sunset: sunsetDate, link: link},
+		{target: "example.com/old/pkg", header: []string{"Accept", "application/json"}, code: http.StatusOK, deprecation: deprecation, sunset: sunsetDate, link: link},
+		{target: "example.com/old/", code: http.StatusFound, deprecation: deprecation, sunset: sunsetDate, link: link},
+		{target: "example.com/old/legacy?go-get=1", code: http.StatusOK, deprecation: deprecation, sunset: sunsetDate, link: link},
+		{target: "example.com/retiring?go-get=1", code: http.StatusOK, deprecation: deprecation},
+	}
+	for _, tt := range tests {
+		w := get(h, tt.target, tt.header...)
+		if w.Code != tt.code {
+			t.Errorf("GET %s: status = %d, want %d", tt.target, w.Code, tt.code)
+		}
+		for _, hdr := range []struct{ name, want string }{
+			{"Deprecation", tt.deprecation},
+			{"Sunset", tt.sunset},
+			{"Link", tt.link},
+		} {
+			if got := w.Header().Get(hdr.name); got != hdr.want {
+				t.Errorf("GET %s: %s = %q, want %q", tt.target, hdr.name, got, hdr.want)
+			}
+		}
+	}
+
+	for _, e := range []redirector.Entry{
+		{ImportPath: "rsc.io/pdf", Repo: "https://github.com/rsc/pdf", Sunset: sunset},
+		{ImportPath: "rsc.io/pdf", Repo: "https://github.com/rsc/pdf", Successor: "https://example.com/new"},
+		{ImportPath: "rsc.io/pdf", Repo: "https://github.com/rsc/pdf", Deprecated: sunset, Sunset: deprecated},
+		{ImportPath: "rsc.io/pdf", Repo: "https://github.com/rsc/pdf", Deprecated: deprecated, Successor: "example.com/new"},
+	} {
+		if _, err := redirector.NewRedirect(e, nil); err == nil {
+			t.Errorf("NewRedirect(%+v) succeeded, want an error", e)
+		}
+	}
+}
+
+func TestEscapedSuffix(t *testing.T) {
+	h := newHandler(t, pkgGoDev(), "rsc.io/*", "https://github.com/rsc/*")
+	tests := []struct {
+		target string
+		meta   string
+		docs   string
+	}{
+		{"rsc.io/pdf/a%20b", "rsc.io/pdf git https://github.com/rsc/pdf", "https://pkg.go.dev/rsc.io/pdf/a%20b"},
+		{"rsc.io/pdf/%C3%A9t%C3%A9", "rsc.io/pdf git https://github.com/rsc/pdf", "https://pkg.go.dev/rsc.io/pdf/%C3%A9t%C3%A9"},
+		{"rsc.io/pdf/a%3Fb%23c", "rsc.io/pdf git https://github.com/rsc/pdf", "https://pkg.go.dev/rsc.io/pdf/a%3Fb%23c"},
+		// The go-import tag holds the logical import path, unescaped, and a valid repo URL.
+		{"rsc.io/%C3%A9t%C3%A9/sub", "rsc.io/été git https://github.com/rsc/%C3%A9t%C3%A9", "https://pkg.go.dev/rsc.io/%C3%A9t%C3%A9/sub"},
+	}
+	for _, tt := range tests {
+		if meta := goImport(get(h, tt.target+"?go-get=1").Body.String()); meta != tt.meta {
+			t.Errorf("GET %s: go-import = %q, want %q", tt.target, meta, tt.meta)
+		}
+		if docs := refresh(get(h, tt.target).Body.String()); docs != tt.docs {
+			t.Errorf("GET %s: refresh = %q, want %q", tt.target, docs, tt.docs)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	h := newHandler(t, pkgGoDev(), "rsc.io/*", "https://github.com/rsc/*")
+	w := get(h, "rsc.io/pdf/sub", "Accept", "text/html;q=0.9, application/json")
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	if vary := w.Header().Get("Vary"); vary != "Accept" {
+		t.Errorf("Vary = %q, want Accept", vary)
+	}
+	var got map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("error decoding %q: %v", w.Body.String(), err)
+	}
+	want := map[string]string{
+		"import_root": "rsc.io/pdf",
+		"vcs":         "git",
+		"vcs_root":    "https://github.com/rsc/pdf",
+		"suffix":      "/sub",
+		"docs_url":    "https://pkg.go.dev/rsc.io/pdf/sub",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("JSON response = %v, want %v", got, want)
+	}
+
+	// JSON is served only when preferred to HTML by its quality, with ties going to HTML.
+	tests := []struct {
+		accept string
+		json   bool
+	}{
+		{"", false},
+		{"text/html", false},
+		{"application/jsonx", false},
+		{"application/json", true},
+		{"application/json;q=0.5", true},
+		{"application/json;q=0", false},
+		{"text/html, application/json;q=0", false},
+		{"text/html, application/json", false},
+		{"text/html;q=0.5, application/json;q=0.8", true},
+		{"application/json;q=0.5, text/html;q=0.8", false},
+		{"text/html;q=0.9, application/json;q=0.9", false},
+		{"*/*, application/json", false},
+		{"*/*;q=0.8, application/json", true},
+		{"text/html;q=0, */*, application/json", true},
+		{"text/*;q=0.1, */*, application/json;q=0.5", true},
+		{"application/json;q=bad", false},
+	}
+	for _, tt := range tests {
+		w := get(h, "rsc.io/pdf", "Accept", tt.accept)
+		isJSON := w.Header().Get("Content-Type") == "application/json"
+		if isJSON != tt.json {
+			t.Errorf("GET with Accept %q: JSON = %t, want %t", tt.accept, isJSON, tt.json)
+		}
+		if !tt.json && goImport(w.Body.String()) == "" {
+			t.Errorf("GET with Accept %q: body %q is not the page", tt.accept, w.Body.String())
+		}
+	}
+}
+
+func TestWildcardRootDocs(t *testing.T) {
+	bases := []string{"https://pkg.go.dev/", "https://docs.example.com/go/"}
+	for _, base := range bases {
+		h := newHandler(t, &redirector.Options{DocsBase: base}, "rsc.io/*", "https://github.com/rsc/*")
+		// The bare root redirects to its docs on the configured host, as the page for a package
+		// under it refreshes to.
+		w := get(h, "rsc.io/")
+		if loc, want := w.Header().Get("Location"), base+"rsc.io"; w.Code != http.StatusFound || loc != want {
+			t.Errorf("docs base %s: GET rsc.io/ = %d to %q, want %d to %q", base, w.Code, loc, http.StatusFound, want)
+		}
+		if docs, want := refresh(get(h, "rsc.io/pdf/sub").Body.String()), base+"rsc.io/pdf/sub"; docs != want {
+			t.Errorf("docs base %s: GET rsc.io/pdf/sub: refresh = %q, want %q", base, docs, want)
+		}
+	}
+
+	// The same holds for a DocsTemplate.
+	opts := &redirector.Options{
+		DocsBase:     "https://pkg.go.dev/",
+		DocsTemplate: texttemplate.Must(texttemplate.New("").Parse(`{{.DocsBase}}{{.ImportRoot}}{{.Suffix}}?tab=doc`)),
+	}
+	h := newHandler(t, opts, "rsc.io/*", "https://github.com/rsc/*")
+	if loc, want := get(h, "rsc.io/").Header().Get("Location"), "https://pkg.go.dev/rsc.io?tab=doc"; loc != want {
+		t.Errorf("GET rsc.io/ with a docs template: Location = %q, want %q", loc, want)
+	}
+	if docs, want := refresh(get(h, "rsc.io/pdf").Body.String()), "https://pkg.go.dev/rsc.io/pdf?tab=doc"; docs != want {
+		t.Errorf("GET rsc.io/pdf with a docs template: refresh = %q, want %q", docs, want)
+	}
+}
+
+func TestGopkgVersions(t *testing.T) {
+	opts := pkgGoDev()
+	opts.GopkgVersions = true
+	opts.GoGetTemplate = template.Must(template.New("").Parse(`{{.ImportRoot}} {{.VCSRoot}} [{{.Ref}}]`))
+	h := newHandler(t, opts, "example.com/*", "https://github.com/example/*")
+	tests := []struct {
+		target string
+		page   string
+	}{
+		{"example.com/pkg.v2", "example.com/pkg.v2 https://github.com/example/pkg [v2]"},
+		{"example.com/pkg.v2/sub", "example.com/pkg.v2 https://github.com/example/pkg [v2]"},
+		{"example.com/pkg.v0", "example.com/pkg.v0 https://github.com/example/pkg [v0]"},
+		// Dots that aren't versions are part of the name.
+		{"example.com/pkg.util", "example.com/pkg.util https://github.com/example/pkg.util []"},
+		{"example.com/pkg.v02", "example.com/pkg.v02 https://github.com/example/pkg.v02 []"},
+		{"example.com/.v1", "example.com/.v1 https://github.com/example/.v1 []"},
+	}
+	for _, tt := range tests {
+		if page := get(h, tt.target+"?go-get=1").Body.String(); page != tt.page {
+			t.Errorf("GET %s: page = %q, want %q", tt.target, page, tt.page)
+		}
+	}
+
+	// Without GopkgVersions, the version is part of the repo name.
+	h = newHandler(t, pkgGoDev(), "example.com/*", "https://github.com/example/*")
+	if meta, want := goImport(get(h, "example.com/pkg.v2?go-get=1").Body.String()), "example.com/pkg.v2 git https://github.com/example/pkg.v2"; meta != want {
+		t.Errorf("GET example.com/pkg.v2 without GopkgVersions: go-import = %q, want %q", meta, want)
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	entries := []redirector.Entry{
+		{
+			ImportPath: "rsc.io/*",
+			Repo:       "https://github.com/rsc/*",
+			Routes: []redirector.Route{
+				{Path: "legacy", Repo: "https://hg.example.com/rsc/legacy", VCS: "hg"},
+				{Path: "/tools/", Repo: "https://gitlab.com/rsc/tools"},
+			},
+		},
+		{
+			ImportPath: "*.example.org/go",
+			Repo:       "https://github.com/*/go",
+			Routes: []redirector.Route{
+				{Path: "legacy", Repo: "hg+https://hg.example.org/*/legacy"},
+				{Path: "shared", Repo: "https://github.com/example/shared"},
+			},
+		},
+	}
+	h, err := redirector.NewHandler(entries, pkgGoDev())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		target   string
+		goImport string
+	}{
+		{"rsc.io/pdf?go-get=1", "rsc.io/pdf git https://github.com/rsc/pdf"},
+		{"rsc.io/legacy?go-get=1", "rsc.io/legacy hg https://hg.example.com/rsc/legacy"},
+		{"rsc.io/legacy/sub/pkg?go-get=1", "rsc.io/legacy hg https://hg.example.com/rsc/legacy"},
+		{"rsc.io/tools/cmd?go-get=1", "rsc.io/tools git https://gitlab.com/rsc/tools"},
+		{"rsc.io/toolsx?go-get=1", "rsc.io/toolsx git https://github.com/rsc/toolsx"},
+		{"alice.example.org/go/pkg?go-get=1", "alice.example.org/go git https://github.com/alice/go"},
+		{"alice.example.org/go/legacy/pkg?go-get=1", "alice.example.org/go/legacy hg https://hg.example.org/alice/legacy"},
+		{"bob.example.org/go/legacy?go-get=1", "bob.example.org/go/legacy hg https://hg.example.org/bob/legacy"},
+		{"bob.example.org/go/shared?go-get=1", "bob.example.org/go/shared git https://github.com/example/shared"},
+	}
+	for _, tt := range tests {
+		w := get(h, tt.target)
+		if w.Code != http.StatusOK {
+			t.Errorf("GET %s: status = %d, want %d", tt.target, w.Code, http.StatusOK)
+			continue
+		}
+		if got := goImport(w.Body.String()); got != tt.goImport {
+			t.Errorf("GET %s: go-import = %q, want %q", tt.target, got, tt.goImport)
+		}
+	}
+
+	e := entries[0]
+	if _, err := redirector.NewRedirect(e, nil); err == nil {
+		t.Errorf("NewRedirect(%q) with routes succeeded, want an error", e.ImportPath)
+	}
+	e.Routes = []redirector.Route{{Path: "/", Repo: "https://github.com/rsc/root"}}
+	if _, err := redirector.NewRedirects(e, nil); err == nil {
+		t.Errorf("NewRedirects(%q) with an empty route path succeeded, want an error", e.ImportPath)
+	}
+}
+
+// metaTags parses page as HTML and returns the content of its named meta tags.
+func metaTags(t *testing.T, page string) map[string]string {
+	t.Helper()
+	doc, err := html.Parse(strings.NewReader(page))
+	if err != nil {
+		t.Fatalf("error parsing %q: %v", page, err)
+	}
+	tags := make(map[string]string)
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "meta" {
+			var name, content string
+			for _, a := range n.Attr {
+				switch a.Key {
+				case "name":
+					name = a.Val
+				case "content":
+					content = a.Val
+				}
+			}
+			if name != "" {
+				tags[name] = content
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return tags
+}
+
+func TestMinify(t *testing.T) {
+	custom := template.Must(template.New("custom").Parse(`<!DOCTYPE html>
+<html>
+<head>
+  <meta name="go-import" content="{{.ImportRoot}} {{.VCS}} {{.VCSRoot}}">
+  {{if .GoSource}}<meta name="go-source" content="{{.GoSource}}">{{end}}
+</head>
+<body>
+  <a href="{{.DocsURL}}">docs</a> <a href="{{.VCSRoot}}">source</a>
+  <pre>
+line one
+line two
+  </pre>
+</body>
+</html>
+`))
+	entries := []redirector.Entry{{
+		ImportPath: "rsc.io/*",
+		Repo:       "https://github.com/rsc/*",
+		SourceDir:  "https://github.com/rsc/{elem}/tree/main{/dir}",
+		SourceFile: "https://github.com/rsc/{elem}/blob/main{/dir}/{file}#L{line}",
+	}}
+	const target = "rsc.io/pdf/sub?go-get=1"
+	for _, tmpl := range []*template.Template{nil, custom} {
+		pages := make([]string, 2)
+		for i, minify := range []bool{false, true} {
+			opts := pkgGoDev()
+			opts.Minify = minify
+			opts.GoGetTemplate = tmpl
+			h, err := redirector.NewHandler(entries, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pages[i] = get(h, target).Body.String()
+		}
+		page, minified := pages[0], pages[1]
+		if len(minified) >= len(page) {
+			t.Errorf("GET %s: minified page %q is no smaller than %q", target, minified, page)
+		}
+		if got, want := metaTags(t, minified), metaTags(t, page); !reflect.DeepEqual(got, want) || want["go-import"] == "" || want["go-source"] == "" {
+			t.Errorf("GET %s: minified meta tags = %q, want %q", target, got, want)
+		}
+		head := minified[:strings.Index(minified, "</head>")]
+		if strings.ContainsAny(head, "\n") || strings.Contains(head, "> <") {
+			t.Errorf("GET %s: minified head %q has whitespace between tags", target, head)
+		}
+		// The body is rendered the same, down to the space between adjacent links.
+		body := func(page string) string { return page[strings.Index(page, "<body>"):] }
+		if got, want := body(minified), strings.TrimSpace(body(page)); got != want {
+			t.Errorf("GET %s: minified body = %q, want %q", target, got, want)
+		}
+	}
+}
+
+func TestVCSAliases(t *testing.T) {
+	opts := pkgGoDev()
+	opts.VCSAliases = map[string]string{"github": "git", "mercurial": "hg"}
+	entries := []redirector.Entry{
+		{ImportPath: "rsc.io/pdf", Repo: "github+https://github.com/rsc/pdf"},
+		{ImportPath: "rsc.io/hg", Repo: "https://hg.example.com/rsc/hg", VCS: "mercurial"},
+		{ImportPath: "rsc.io/svn", Repo: "svn+https://svn.example.com/rsc/svn"},
+	}
+	h, err := redirector.NewHandler(entries, opts)
+	if err != nil {
+		t.Fatalf("NewHandler failed: %v", err)
+	}
+	tests := []struct {
+		target   string
+		goImport string
+	}{
+		{"rsc.io/pdf", "rsc.io/pdf git https://github.com/rsc/pdf"},
+		{"rsc.io/hg", "rsc.io/hg hg https://hg.example.com/rsc/hg"},
+		{"rsc.io/svn", "rsc.io/svn svn https://svn.example.com/rsc/svn"},
+	}
+	for _, tt := range tests {
+		if got := goImport(get(h, tt.target+"?go-get=1").Body.String()); got != tt.goImport {
+			t.Errorf("GET %s: go-import = %q, want %q", tt.target, got, tt.goImport)
+		}
+	}
+
+	// An unknown VCS is an error unless aliased to a known one.
+	for _, e := range []redirector.Entry{
+		{ImportPath: "rsc.io/pdf", Repo: "github+https://github.com/rsc/pdf"},
+		{ImportPath: "rsc.io/pdf", Repo: "https://github.com/rsc/pdf", VCS: "gti"},
+	} {
+		if _, err := redirector.NewRedirect(e, pkgGoDev()); err == nil || !strings.Contains(err.Error(), "unknown version control system") {
+			t.Errorf("NewRedirect(%s, %s, VCS %q) without aliases = %v, want an unknown VCS error", e.ImportPath, e.Repo, e.VCS, err)
+		}
+	}
+	opts.VCSAliases = map[string]string{"github": "gitt"}
+	if _, err := redirector.NewRedirect(entries[0], opts); err == nil {
+		t.Errorf("NewRedirect with an alias to an unknown VCS succeeded, want an error")
+	}
+}
+
+// canonicalRE matches the canonical link in a page.
+var canonicalRE = regexp.MustCompile(`<link rel="canonical" href="([^"]*)">`)
+
+func TestCanonical(t *testing.T) {
+	tests := []struct {
+		canonical bool
+		docsBase  string
+		target    string
+		want      string
+	}{
+		{false, "https://pkg.go.dev/", "rsc.io/pdf/sub", ""},
+		{true, "https://pkg.go.dev/", "rsc.io/pdf/sub", "https://pkg.go.dev/rsc.io/pdf/sub"},
+		{true, "https://pkg.go.dev/", "rsc.io/pdf?go-get=1", "https://pkg.go.dev/rsc.io/pdf"},
+		// With no documentation there is nothing to link to.
+		{true, "", "rsc.io/pdf", ""},
+	}
+	for _, tt := range tests {
+		opts := &redirector.Options{DocsBase: tt.docsBase, Canonical: tt.canonical}
+		h := newHandler(t, opts, "rsc.io/*", "https://github.com/rsc/*")
+		w := get(h, tt.target)
+		if w.Code != http.StatusOK {
+			t.Errorf("GET %s: status = %d, want %d", tt.target, w.Code, http.StatusOK)
+			continue
+		}
+		body := w.Body.String()
+		var got string
+		if m := canonicalRE.FindStringSubmatch(body); m != nil {
+			got = m[1]
+		}
+		if got != tt.want {
+			t.Errorf("GET %s with Canonical %t and DocsBase %q: canonical = %q, want %q", tt.target, tt.canonical, tt.docsBase, got, tt.want)
+		}
+		if i, j := strings.Index(body, `rel="canonical"`), strings.Index(body, "</head>"); i > j {
+			t.Errorf("GET %s: canonical link is not in the head of %q", tt.target, body)
+		}
+		if goImport(body) != "rsc.io/pdf git https://github.com/rsc/pdf" {
+			t.Errorf("GET %s: go-import = %q", tt.target, goImport(body))
+		}
+	}
+}
+
+// jsonLDRE matches the JSON-LD script in a page.
+var jsonLDRE = regexp.MustCompile(`<script type="application/ld\+json">([^<]*)</script>`)
+
+func TestJSONLD(t *testing.T) {
+	opts := pkgGoDev()
+	opts.JSONLD = true
+	h := newHandler(t, opts, "rsc.io/*", "https://github.com/rsc/*")
+	body := get(h, "rsc.io/pdf/sub").Body.String()
+	m := jsonLDRE.FindStringSubmatch(body)
+	if m == nil {
+		t.Fatalf("GET rsc.io/pdf/sub: page %q has no JSON-LD", body)
+	}
+	if i, j := strings.Index(body, "application/ld+json"), strings.Index(body, "</head>"); i > j {
+		t.Errorf("GET rsc.io/pdf/sub: JSON-LD is not in the head of %q", body)
+	}
+	var got map[string]string
+	if err := json.Unmarshal([]byte(m[1]), &got); err != nil {
+		t.Fatalf("GET rsc.io/pdf/sub: error decoding JSON-LD %q: %v", m[1], err)
+	}
+	want := map[string]string{
+		"@context":            "https://schema.org",
+		"@type":               "SoftwareSourceCode",
+		"name":                "rsc.io/pdf/sub",
+		"programmingLanguage": "Go",
+		"codeRepository":      "https://github.com/rsc/pdf",
+		"url":                 "https://pkg.go.dev/rsc.io/pdf/sub",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GET rsc.io/pdf/sub: JSON-LD = %v, want %v", got, want)
+	}
+
+	// Without documentation the URL is left out, and markup in paths can't end the script.
+	opts = &redirector.Options{JSONLD: true}
+	h = newHandler(t, opts, "rsc.io/*", "https://github.com/rsc/*")
+	body = get(h, "rsc.io/pdf/</script>").Body.String()
+	m = jsonLDRE.FindStringSubmatch(body)
+	if m == nil {
+		t.Fatalf("GET rsc.io/pdf/</script>: page %q has no intact JSON-LD", body)
+	}
+	got = nil
+	if err := json.Unmarshal([]byte(m[1]), &got); err != nil {
+		t.Fatalf("GET rsc.io/pdf/</script>: error decoding JSON-LD %q: %v", m[1], err)
+	}
+	if _, ok := got["url"]; ok || got["name"] != "rsc.io/pdf/</script>" {
+		t.Errorf("GET rsc.io/pdf/</script> without docs: JSON-LD = %v, want a name and no url", got)
+	}
+
+	// It is off by default.
+	if body := get(newHandler(t, pkgGoDev(), "rsc.io/*", "https://github.com/rsc/*"), "rsc.io/pdf").Body.String(); strings.Contains(body, "ld+json") {
+		t.Errorf("GET rsc.io/pdf without JSONLD: page %q has JSON-LD", body)
+	}
+}
@@ -0,0 +1,190 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package redirector
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Router dispatches each request to the redirect with the longest import path that matches it, so
+// that overlapping import paths such as example.com/foo and example.com/foo/bar are resolved the
+// same way regardless of the order they are given in. Import paths on a single host are matched
+// before subdomain wildcards, so that teamx.example.com/foo takes precedence over *.example.com/*.
+type Router struct {
+	opts      *Options
+	redirects []*Redirect // sorted by host wildcards last, then decreasing import path length
+	index     []byte      // page served at / if no redirect matches, if any
+	roots     []string    // import paths, sorted
+}
+
+// NewRouter returns a Router for redirects, using the HealthPath, Index, and NotFoundTemplate
+// options of opts. If opts is nil, the zero Options are used. It is an error for two redirects to
+// have the same import path.
+func NewRouter(redirects []*Redirect, opts *Options) (*Router, error) {
+	if opts == nil {
+		opts = new(Options)
+	}
+	rt := &Router{
+		opts:      opts,
+		redirects: append([]*Redirect(nil), redirects...),
+	}
+	sort.SliceStable(rt.redirects, func(i, j int) bool {
+		a, b := rt.redirects[i], rt.redirects[j]
+		if a.Subdomains() != b.Subdomains() {
+			return b.Subdomains()
+		}
+		return len(a.importPath) > len(b.importPath)
+	})
+	seen := make(map[string]bool, len(redirects))
+	for _, r := range rt.redirects {
+		if seen[r.importPath] {
+			return nil, fmt.Errorf("duplicate import path %s", r.importPath)
+		}
+		seen[r.importPath] = true
+		rt.roots = append(rt.roots, r.importPath)
+	}
+	sort.Strings(rt.roots)
+	if opts.Index {
+		page, err := indexPage(redirects)
+		if err != nil {
+			return nil, err
+		}
+		rt.index = page
+	}
+	return rt, nil
+}
+
+// indexEntry is a row of the index page.
+type indexEntry struct {
+	ImportPath string
+	VCS        string
+	Repo       string
+}
+
+// Len returns the number of redirects served by rt.
+func (rt *Router) Len() int {
+	return len(rt.redirects)
+}
+
+// indexPage renders the index page listing redirects, sorted by import path.
+func indexPage(redirects []*Redirect) ([]byte, error) {
+	entries := make([]indexEntry, 0, len(redirects))
+	for _, r := range redirects {
+		e := indexEntry{VCS: r.vcs}
+		e.ImportPath, e.Repo = r.Patterns()
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ImportPath < entries[j].ImportPath
+	})
+
+	var buf bytes.Buffer
+	if err := indexTmpl.Execute(&buf, entries); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// match returns the redirect with the longest import path that is either reqPath or a parent of
+// it, and that import path on the host of reqPath, or nil if there is none.
+func (rt *Router) match(reqPath string) (*Redirect, string) {
+	host := reqPath
+	if i := strings.IndexByte(host, '/'); i >= 0 {
+		host = host[:i]
+	}
+	for _, r := range rt.redirects {
+		importPath, _, ok := r.onHost(host)
+		if ok && (reqPath == importPath || strings.HasPrefix(reqPath, importPath+"/")) {
+			return r, importPath
+		}
+	}
+	return nil, ""
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// Bad methods and over-long paths are rejected before any work is spent matching them, whether
+	// or not a redirect would serve them, and for health checks and the index page too.
+	if rt.opts.StrictMethods && !checkMethod(w, req) {
+		return
+	}
+	if limit := rt.opts.MaxPathLen; limit > 0 && len(req.URL.Path) > limit {
+		http.Error(w, "request path too long", http.StatusRequestURITooLong)
+		return
+	}
+
+	// As with http.ServeMux, send requests for unclean paths to the cleaned path.
+	if p := cleanPath(req.URL.Path); p != req.URL.Path {
+		// Only the path and query are kept, so the redirect stays on the host it was requested on.
+		u := url.URL{Path: p, RawQuery: req.URL.RawQuery}
+		http.Redirect(w, req, u.String(), http.StatusMovedPermanently)
+		return
+	}
+
+	if rt.opts.NotFoundTemplate != nil {
+		req = withRoots(req, rt.roots)
+	}
+	reqPath := requestHost(req) + req.URL.Path
+	r, importPath := rt.match(reqPath)
+	robots := rt.opts.Robots
+	if robots != "" && req.URL.Path == "/robots.txt" && (r == nil || importPath != reqPath) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Length", strconv.Itoa(len(robots)))
+		io.WriteString(w, robots)
+		return
+	}
+	if r != nil {
+		r.ServeHTTP(w, req)
+		return
+	}
+	if health := rt.opts.HealthPath; health != "" && req.URL.Path == health {
+		rt.pong(w, req)
+		return
+	}
+	if rt.index != nil && req.URL.Path == "/" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Length", strconv.Itoa(len(rt.index)))
+		w.Write(rt.index)
+		return
+	}
+	rt.opts.notFound(w, req)
+}
+
+// cleanPath returns p with . and .. elements and repeated slashes removed, keeping any trailing
+// slash.
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if p[0] != '/' {
+		p = "/" + p
+	}
+	np := path.Clean(p)
+	if strings.HasSuffix(p, "/") && np != "/" {
+		np += "/"
+	}
+	return np
+}
+
+// pong answers a health check, with a 503 Service Unavailable if the HealthCheck option reports an
+// error.
+func (rt *Router) pong(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if check := rt.opts.HealthCheck; check != nil {
+		if err := check(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, err)
+			return
+		}
+	}
+	fmt.Fprintf(w, "pong")
+}
@@ -0,0 +1,307 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package redirector_test
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+	"testing"
+
+	"go.spiff.io/go-import-redirector/redirector"
+)
+
+func TestOverlappingRoots(t *testing.T) {
+	// The redirects are given least specific first, so that registration order can't pick the
+	// match.
+	h := newHandler(t, pkgGoDev(),
+		"example.com/*", "https://github.com/example/*",
+		"example.com/foo", "https://github.com/example/foo",
+		"example.com/foo/bar", "https://github.com/example/foobar")
+	tests := []struct {
+		target string
+		meta   string
+	}{
+		{"example.com/foo/bar/baz", "example.com/foo/bar git https://github.com/example/foobar"},
+		{"example.com/foo/bar", "example.com/foo/bar git https://github.com/example/foobar"},
+		{"example.com/foo/barn", "example.com/foo git https://github.com/example/foo"},
+		{"example.com/foo/qux", "example.com/foo git https://github.com/example/foo"},
+		{"example.com/foo", "example.com/foo git https://github.com/example/foo"},
+		{"example.com/food/x", "example.com/food git https://github.com/example/food"},
+	}
+	for _, tt := range tests {
+		w := get(h, tt.target+"?go-get=1")
+		if meta := goImport(w.Body.String()); w.Code != http.StatusOK || meta != tt.meta {
+			t.Errorf("GET %s = %d with go-import %q, want %d with %q", tt.target, w.Code, meta, http.StatusOK, tt.meta)
+		}
+	}
+}
+
+func TestHostname(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"rsc.io", "rsc.io"},
+		{"rsc.io:9001", "rsc.io"},
+		{"127.0.0.1:9001", "127.0.0.1"},
+		{"[::1]:9001", "::1"},
+		{"[::1]", "::1"},
+		{"::1", "::1"},
+	}
+	for _, tt := range tests {
+		if got := redirector.Hostname(tt.host); got != tt.want {
+			t.Errorf("Hostname(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestHostPort(t *testing.T) {
+	h := newHandler(t, pkgGoDev(),
+		"rsc.io/*", "https://github.com/rsc/*",
+		"9fans.net/go", "https://github.com/9fans/go",
+		"::1/pkg", "https://github.com/example/pkg")
+	tests := []struct {
+		target string
+		meta   string
+	}{
+		{"rsc.io:9001/pdf", "rsc.io/pdf git https://github.com/rsc/pdf"},
+		{"9fans.net:9001/go/draw", "9fans.net/go git https://github.com/9fans/go"},
+		{"[::1]:9001/pkg", "::1/pkg git https://github.com/example/pkg"},
+		{"[::1]/pkg", "::1/pkg git https://github.com/example/pkg"},
+	}
+	for _, tt := range tests {
+		w := get(h, tt.target+"?go-get=1")
+		if meta := goImport(w.Body.String()); w.Code != http.StatusOK || meta != tt.meta {
+			t.Errorf("GET %s = %d with go-import %q, want %d with %q", tt.target, w.Code, meta, http.StatusOK, tt.meta)
+		}
+	}
+}
+
+func TestIndex(t *testing.T) {
+	opts := pkgGoDev()
+	opts.Index = true
+	h := newHandler(t, opts,
+		"rsc.io/*", "https://github.com/rsc/*",
+		"9fans.net/go", "https://github.com/9fans/go",
+		"example.com/hg", "hg+https://hg.example.com/hg")
+	// The index is served on any host, as long as no redirect matches /.
+	w := get(h, "example.com/")
+	body := w.Body.String()
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET example.com/: status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if strings.Contains(body, `name="go-import"`) {
+		t.Errorf("index page %q has a go-import tag", body)
+	}
+	for _, s := range []string{
+		"rsc.io/*", "https://github.com/rsc/*",
+		"9fans.net/go", "https://github.com/9fans/go",
+		"example.com/hg", "https://hg.example.com/hg", "hg",
+	} {
+		if !strings.Contains(body, s) {
+			t.Errorf("index page %q does not list %s", body, s)
+		}
+	}
+
+	// Import paths are still redirected, including an import root on /.
+	if w := get(h, "rsc.io/"); w.Code != http.StatusFound {
+		t.Errorf("GET rsc.io/ with Index: status = %d, want %d", w.Code, http.StatusFound)
+	}
+	if meta := goImport(get(h, "rsc.io/pdf?go-get=1").Body.String()); meta != "rsc.io/pdf git https://github.com/rsc/pdf" {
+		t.Errorf("GET rsc.io/pdf with Index: go-import = %q, want %q", meta, "rsc.io/pdf git https://github.com/rsc/pdf")
+	}
+
+	// Without Index, there is no index page.
+	h = newHandler(t, pkgGoDev(), "9fans.net/go", "https://github.com/9fans/go")
+	if w := get(h, "9fans.net/"); w.Code != http.StatusNotFound {
+		t.Errorf("GET 9fans.net/ without Index: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestNotFoundTemplate(t *testing.T) {
+	opts := pkgGoDev()
+	opts.NotFoundTemplate = template.Must(template.New("").Parse(
+		`no {{.Host}}{{.Path}} in{{range .Roots}} {{.}}{{end}}`))
+	h := newHandler(t, opts,
+		"rsc.io/*", "https://github.com/rsc/*",
+		"9fans.net/go", "https://github.com/9fans/go")
+	tests := []struct {
+		target string
+		body   string
+	}{
+		{"Example.com/pkg", "no example.com/pkg in 9fans.net/go rsc.io"},
+		{"9fans.net/other", "no 9fans.net/other in 9fans.net/go rsc.io"},
+	}
+	for _, tt := range tests {
+		w := get(h, tt.target)
+		if w.Code != http.StatusNotFound || w.Body.String() != tt.body {
+			t.Errorf("GET %s = %d with %q, want %d with %q", tt.target, w.Code, w.Body.String(), http.StatusNotFound, tt.body)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+			t.Errorf("GET %s: Content-Type = %q, want HTML", tt.target, ct)
+		}
+	}
+
+	// Without a template, the plain 404 is served.
+	h = newHandler(t, pkgGoDev(), "rsc.io/*", "https://github.com/rsc/*")
+	if w := get(h, "example.com/pkg"); w.Code != http.StatusNotFound || w.Body.String() != "404 page not found\n" {
+		t.Errorf("GET example.com/pkg without a template = %d with %q", w.Code, w.Body.String())
+	}
+}
+
+func TestTrailingSlashes(t *testing.T) {
+	opts := pkgGoDev()
+	opts.BrowserTemplate = template.Must(template.New("").Parse(`{{.ImportRoot}} [{{.Suffix}}] {{.DocsURL}}`))
+	h := newHandler(t, opts,
+		"example.com/foo", "https://github.com/example/foo",
+		"rsc.io/*", "https://github.com/rsc/*")
+	tests := []struct {
+		target string
+		meta   string
+		page   string
+	}{
+		{"example.com/foo", "example.com/foo git https://github.com/example/foo", "example.com/foo [] https://pkg.go.dev/example.com/foo"},
+		{"example.com/foo/", "example.com/foo git https://github.com/example/foo", "example.com/foo [] https://pkg.go.dev/example.com/foo"},
+		{"example.com/foo/bar", "example.com/foo git https://github.com/example/foo", "example.com/foo [/bar] https://pkg.go.dev/example.com/foo/bar"},
+		{"example.com/foo/bar/", "example.com/foo git https://github.com/example/foo", "example.com/foo [/bar] https://pkg.go.dev/example.com/foo/bar"},
+		{"rsc.io/pdf/", "rsc.io/pdf git https://github.com/rsc/pdf", "rsc.io/pdf [] https://pkg.go.dev/rsc.io/pdf"},
+		{"rsc.io/pdf/sub/", "rsc.io/pdf git https://github.com/rsc/pdf", "rsc.io/pdf [/sub] https://pkg.go.dev/rsc.io/pdf/sub"},
+	}
+	for _, tt := range tests {
+		if meta := goImport(get(h, tt.target+"?go-get=1").Body.String()); meta != tt.meta {
+			t.Errorf("GET %s: go-import = %q, want %q", tt.target, meta, tt.meta)
+		}
+		if page := get(h, tt.target).Body.String(); page != tt.page {
+			t.Errorf("GET %s: page = %q, want %q", tt.target, page, tt.page)
+		}
+	}
+}
+
+func TestCatchAll(t *testing.T) {
+	h := newHandler(t, pkgGoDev(),
+		"example.com/*", "https://github.com/example/*",
+		"example.com/tools", "https://git.example.com/tools",
+		"example.com/legacy/*", "https://hg.example.com/*")
+	tests := []struct {
+		target string
+		meta   string
+		docs   string
+	}{
+		{"example.com/tools/cmd", "example.com/tools git https://git.example.com/tools", "https://pkg.go.dev/example.com/tools/cmd"},
+		{"example.com/legacy/old", "example.com/legacy/old git https://hg.example.com/old", "https://pkg.go.dev/example.com/legacy/old"},
+		// Anything else on the domain falls through to the catch-all.
+		{"example.com/other/sub", "example.com/other git https://github.com/example/other", "https://pkg.go.dev/example.com/other/sub"},
+		{"example.com/toolsx", "example.com/toolsx git https://github.com/example/toolsx", "https://pkg.go.dev/example.com/toolsx"},
+	}
+	for _, tt := range tests {
+		if meta := goImport(get(h, tt.target+"?go-get=1").Body.String()); meta != tt.meta {
+			t.Errorf("GET %s: go-import = %q, want %q", tt.target, meta, tt.meta)
+		}
+		if docs := refresh(get(h, tt.target).Body.String()); docs != tt.docs {
+			t.Errorf("GET %s: refresh = %q, want %q", tt.target, docs, tt.docs)
+		}
+	}
+	if w := get(h, "example.org/other"); w.Code != http.StatusNotFound {
+		t.Errorf("GET example.org/other: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestMixedCaseHosts(t *testing.T) {
+	h := newHandler(t, pkgGoDev(),
+		"RSC.io/*", "https://github.com/rsc/*",
+		"9fans.net/Go", "https://github.com/9fans/go")
+	tests := []struct {
+		target string
+		meta   string
+	}{
+		{"Rsc.IO/x86", "rsc.io/x86 git https://github.com/rsc/x86"},
+		{"rsc.io/x86", "rsc.io/x86 git https://github.com/rsc/x86"},
+		{"9FANS.net/Go/draw", "9fans.net/Go git https://github.com/9fans/go"},
+		// Only the host is matched without regard to case.
+		{"9fans.net/go/draw", ""},
+	}
+	for _, tt := range tests {
+		if meta := goImport(get(h, tt.target+"?go-get=1").Body.String()); meta != tt.meta {
+			t.Errorf("GET %s: go-import = %q, want %q", tt.target, meta, tt.meta)
+		}
+	}
+}
+
+func TestRobots(t *testing.T) {
+	const policy = "User-agent: *\nDisallow: /\n"
+	opts := pkgGoDev()
+	opts.Robots = policy
+	h := newHandler(t, opts,
+		"rsc.io/*", "https://github.com/rsc/*",
+		"example.com/robots.txt", "https://github.com/example/robots")
+	for _, target := range []string{"rsc.io/robots.txt", "9fans.net/robots.txt"} {
+		w := get(h, target)
+		if w.Code != http.StatusOK || w.Body.String() != policy {
+			t.Errorf("GET %s = %d with %q, want %d with %q", target, w.Code, w.Body.String(), http.StatusOK, policy)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+			t.Errorf("GET %s: Content-Type = %q, want plain text", target, ct)
+		}
+	}
+	// An import path that is exactly host/robots.txt is not shadowed.
+	if meta, want := goImport(get(h, "example.com/robots.txt?go-get=1").Body.String()), "example.com/robots.txt git https://github.com/example/robots"; meta != want {
+		t.Errorf("GET example.com/robots.txt: go-import = %q, want %q", meta, want)
+	}
+
+	// Without a policy, robots.txt is an ordinary path.
+	h = newHandler(t, pkgGoDev(), "rsc.io/*", "https://github.com/rsc/*")
+	if meta, want := goImport(get(h, "rsc.io/robots.txt?go-get=1").Body.String()), "rsc.io/robots.txt git https://github.com/rsc/robots.txt"; meta != want {
+		t.Errorf("GET rsc.io/robots.txt without Robots: go-import = %q, want %q", meta, want)
+	}
+}
+
+func TestSubdomains(t *testing.T) {
+	h := newHandler(t, pkgGoDev(),
+		"*.example.com/*", "https://github.com/*/*",
+		"teamz.example.com/pkg", "https://gitlab.com/teamz/pkg")
+	tests := []struct {
+		target  string
+		meta    string
+		refresh string
+	}{
+		{"teamx.example.com/pkg", "teamx.example.com/pkg git https://github.com/teamx/pkg", "https://pkg.go.dev/teamx.example.com/pkg"},
+		{"teamx.example.com/pkg/sub", "teamx.example.com/pkg git https://github.com/teamx/pkg", "https://pkg.go.dev/teamx.example.com/pkg/sub"},
+		{"TeamY.example.com/tool", "teamy.example.com/tool git https://github.com/teamy/tool", "https://pkg.go.dev/teamy.example.com/tool"},
+		// An import path on a single host takes precedence over the wildcard.
+		{"teamz.example.com/pkg", "teamz.example.com/pkg git https://gitlab.com/teamz/pkg", "https://pkg.go.dev/teamz.example.com/pkg"},
+		{"teamz.example.com/other", "teamz.example.com/other git https://github.com/teamz/other", "https://pkg.go.dev/teamz.example.com/other"},
+	}
+	for _, tt := range tests {
+		w := get(h, tt.target+"?go-get=1")
+		if w.Code != http.StatusOK {
+			t.Errorf("GET %s: status = %d, want %d", tt.target, w.Code, http.StatusOK)
+			continue
+		}
+		body := w.Body.String()
+		if meta := goImport(body); meta != tt.meta {
+			t.Errorf("GET %s: go-import = %q, want %q", tt.target, meta, tt.meta)
+		}
+		if got := refresh(body); got != tt.refresh {
+			t.Errorf("GET %s: refresh = %q, want %q", tt.target, got, tt.refresh)
+		}
+	}
+
+	// Only a single label under the domain is matched, not the apex or deeper subdomains.
+	for _, target := range []string{"example.com/pkg", "a.b.example.com/pkg", "notexample.com/pkg"} {
+		if w := get(h, target+"?go-get=1"); w.Code != http.StatusNotFound {
+			t.Errorf("GET %s: status = %d, want %d", target, w.Code, http.StatusNotFound)
+		}
+	}
+
+	for _, e := range []redirector.Entry{
+		{ImportPath: "*.example.com/*", Repo: "https://github.com/example/*"},
+		{ImportPath: "*.example.com/pkg", Repo: "https://github.com/example/pkg"},
+		{ImportPath: "a.*.example.com/pkg", Repo: "https://github.com/*/pkg"},
+	} {
+		if _, err := redirector.NewRedirect(e, nil); err == nil {
+			t.Errorf("NewRedirect(%s -> %s) succeeded, want an error", e.ImportPath, e.Repo)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package redirector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	texttemplate "text/template"
+	"unicode"
+)
+
+// DefaultTemplate is the page served for a redirect unless the GoGetTemplate or BrowserTemplate
+// option is set. It is executed with a Data.
+var DefaultTemplate = template.Must(template.New("main").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8"/>
+<meta name="go-import" content="{{.ImportRoot}} {{.VCS}} {{.VCSRoot}}">
+{{if and .DocsURL .Refresh}}<meta http-equiv="refresh" content="0; url={{.DocsURL}}">
+{{end}}{{if .GoSource}}<meta name="go-source" content="{{.GoSource}}">
+{{end}}{{if .Canonical}}<link rel="canonical" href="{{.Canonical}}">
+{{end}}{{if .JSONLD}}<script type="application/ld+json">{{.JSONLD}}</script>
+{{end}}</head>
+<body>
+{{if .Message}}<p>{{.Message}}</p>
+{{else if .DocsURL}}{{if .Refresh}}Redirecting to docs at{{else}}Go to docs at{{end}} <a href="{{.DocsURL}}">{{.DocsURL}}</a>...
+{{end}}</body>
+</html>
+`))
+
+// MinimalTemplate is a page holding only the meta tags read by ``go get'' and other tools, with no
+// documentation redirect or body, for use as a GoGetTemplate.
+var MinimalTemplate = template.Must(template.New("minimal").Parse(`<!DOCTYPE html>
+<html><head>
+<meta name="go-import" content="{{.ImportRoot}} {{.VCS}} {{.VCSRoot}}">
+{{if .GoSource}}<meta name="go-source" content="{{.GoSource}}">
+{{end}}</head></html>
+`))
+
+var indexTmpl = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8"/>
+<title>Import paths</title>
+</head>
+<body>
+<table>
+<tr><th>Import path</th><th>VCS</th><th>Repository</th></tr>
+{{range .}}<tr><td>{{.ImportPath}}</td><td>{{.VCS}}</td><td>{{.Repo}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// Data is passed to page templates for a request. DocsURL is empty if documentation redirects are
+// disabled, GoSource is empty unless source URL templates are configured, and Message is empty
+// unless the entry has one.
+type Data struct {
+	ImportRoot string
+	VCS        string
+	VCSRoot    string
+	Ref        string
+	Suffix     string
+	DocsBase   string
+	DocsURL    string
+	Refresh    bool
+	Canonical  string
+	JSONLD     template.JS
+	GoSource   string
+	Message    string
+}
+
+// sampleData is used to check that templates execute successfully when parsed.
+var sampleData = Data{
+	ImportRoot: "example.com/pkg",
+	VCS:        "git",
+	VCSRoot:    "https://example.com/pkg",
+	Ref:        "main",
+	Suffix:     "/sub",
+	DocsBase:   "https://pkg.go.dev/",
+	DocsURL:    "https://pkg.go.dev/example.com/pkg/sub",
+	Refresh:    true,
+	Canonical:  "https://pkg.go.dev/example.com/pkg/sub",
+	JSONLD:     `{"@context":"https://schema.org"}`,
+	GoSource:   "example.com/pkg https://example.com/pkg https://example.com/pkg{/dir} https://example.com/pkg{/dir}/{file}#L{line}",
+	Message:    "This package is in maintenance mode.",
+}
+
+// RenderMeta writes the page for d, using DefaultTemplate, to w.
+func RenderMeta(w io.Writer, d *Data) error {
+	return DefaultTemplate.Execute(w, d)
+}
+
+// ParsePageTemplate parses the html/template in file for use as a GoGetTemplate or
+// BrowserTemplate, and checks that it can be executed with a Data.
+func ParsePageTemplate(file string) (*template.Template, error) {
+	return parseTemplateFile(file, &sampleData)
+}
+
+// parseTemplateFile parses the html/template in file and checks that it can be executed with
+// sample.
+func parseTemplateFile(file string, sample interface{}) (*template.Template, error) {
+	t, err := template.ParseFiles(file)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Execute(ioutil.Discard, sample); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// DefaultDocsTemplate is the text of the template used to build documentation URLs unless the
+// DocsTemplate option is set.
+const DefaultDocsTemplate = "{{.DocsBase}}{{.ImportRoot}}{{.Suffix}}"
+
+var defaultDocsTmpl = texttemplate.Must(texttemplate.New("docs").Parse(DefaultDocsTemplate))
+
+// ParseDocsTemplate parses text as a template for documentation URLs and checks that it produces
+// an absolute URL.
+func ParseDocsTemplate(text string) (*texttemplate.Template, error) {
+	t, err := texttemplate.New("docs").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, &sampleData); err != nil {
+		return nil, err
+	}
+	if u, err := url.Parse(buf.String()); err != nil || !u.IsAbs() {
+		return nil, fmt.Errorf("template must produce an absolute URL, got %q", buf.String())
+	}
+	return t, nil
+}
+
+// docsURL returns the documentation URL for d. The import root and suffix are percent-encoded for
+// use in a URL path, so that paths with spaces or other special characters still produce a valid
+// URL.
+func (o *Options) docsURL(d *Data) (string, error) {
+	t := o.DocsTemplate
+	if t == nil {
+		t = defaultDocsTmpl
+	}
+	escaped := *d
+	escaped.ImportRoot = escapePath(d.ImportRoot)
+	escaped.Suffix = escapePath(d.Suffix)
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, &escaped); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// escapePath percent-encodes p for use as a URL path, leaving its slashes as they are.
+func escapePath(p string) string {
+	return (&url.URL{Path: p}).EscapedPath()
+}
+
+var (
+	// interTagSpace matches whitespace between the end of one tag and the start of another.
+	interTagSpace = regexp.MustCompile(`>\s+<`)
+	// tagLineSpace matches line breaks between a tag and text.
+	tagLineSpace = regexp.MustCompile(`(>)\s*\n\s*|\s*\n\s*(<)`)
+	// headEnd matches the end tag of the head element and any whitespace after it.
+	headEnd = regexp.MustCompile(`(?i)</head>\s*`)
+)
+
+// minifyHTML removes whitespace between tags and line breaks between tags and text up to the end of
+// the head element, and whitespace at the ends of page. The body is left as it is, since whitespace
+// between its elements, such as between two links, is rendered; a page without a head element only
+// has its ends trimmed.
+func minifyHTML(page []byte) []byte {
+	page = bytes.TrimSpace(page)
+	loc := headEnd.FindIndex(page)
+	if loc == nil {
+		return page
+	}
+	head := interTagSpace.ReplaceAll(page[:loc[1]], []byte("><"))
+	head = tagLineSpace.ReplaceAll(head, []byte("$1$2"))
+	head = bytes.TrimRightFunc(head, unicode.IsSpace)
+	return append(head[:len(head):len(head)], page[loc[1]:]...)
+}
+
+// packageJSONLD returns a schema.org JSON-LD description of the package for d.
+func packageJSONLD(d *Data) (template.JS, error) {
+	b, err := json.Marshal(struct {
+		Context        string `json:"@context"`
+		Type           string `json:"@type"`
+		Name           string `json:"name"`
+		Language       string `json:"programmingLanguage"`
+		CodeRepository string `json:"codeRepository"`
+		URL            string `json:"url,omitempty"`
+	}{
+		Context:        "https://schema.org",
+		Type:           "SoftwareSourceCode",
+		Name:           d.ImportRoot + d.Suffix,
+		Language:       "Go",
+		CodeRepository: d.VCSRoot,
+		URL:            d.DocsURL,
+	})
+	if err != nil {
+		return "", err
+	}
+	// json.Marshal escapes <, >, and &, so b is safe to include in a script element.
+	return template.JS(b), nil
+}
@@ -0,0 +1,52 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package redirector_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"go.spiff.io/go-import-redirector/redirector"
+)
+
+func TestMinimalTemplate(t *testing.T) {
+	d := &redirector.Data{
+		ImportRoot: "rsc.io/pdf",
+		VCS:        "git",
+		VCSRoot:    "https://github.com/rsc/pdf",
+		DocsURL:    "https://pkg.go.dev/rsc.io/pdf",
+		Refresh:    true,
+	}
+	var full, minimal bytes.Buffer
+	if err := redirector.DefaultTemplate.Execute(&full, d); err != nil {
+		t.Fatal(err)
+	}
+	if err := redirector.MinimalTemplate.Execute(&minimal, d); err != nil {
+		t.Fatal(err)
+	}
+	if minimal.Len() >= full.Len() {
+		t.Errorf("minimal page is %d bytes, want fewer than the %d of the full page", minimal.Len(), full.Len())
+	}
+	page := minimal.String()
+	if meta, want := goImport(page), "rsc.io/pdf git https://github.com/rsc/pdf"; meta != want {
+		t.Errorf("minimal page go-import = %q, want %q", meta, want)
+	}
+	for _, s := range []string{"refresh", "<body", d.DocsURL} {
+		if strings.Contains(page, s) {
+			t.Errorf("minimal page %q contains %q", page, s)
+		}
+	}
+
+	// As a GoGetTemplate, browsers still get the full page.
+	opts := pkgGoDev()
+	opts.GoGetTemplate = redirector.MinimalTemplate
+	h := newHandler(t, opts, "rsc.io/*", "https://github.com/rsc/*")
+	if body := get(h, "rsc.io/pdf?go-get=1").Body.String(); body != page {
+		t.Errorf("go get page = %q, want %q", body, page)
+	}
+	if body := get(h, "rsc.io/pdf").Body.String(); body != full.String() {
+		t.Errorf("browser page = %q, want %q", body, full.String())
+	}
+}
@@ -0,0 +1,77 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// maxRequestIDLen is the longest X-Request-ID accepted from a client. Longer or otherwise invalid
+// IDs are ignored.
+const maxRequestIDLen = 128
+
+type requestIDKey struct{}
+
+// withRequestID returns a handler that assigns requests an ID before passing them to next. The ID
+// is taken from the request's X-Request-ID header, if valid, or generated if generate is true, and
+// is echoed back in the response's X-Request-ID header. Requests without a valid ID are otherwise
+// passed to next unchanged.
+func withRequestID(next http.Handler, generate bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			if !generate {
+				next.ServeHTTP(w, req)
+				return
+			}
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), requestIDKey{}, id)))
+	})
+}
+
+// requestID returns the ID assigned to req by withRequestID, or "-" if it has none.
+func requestID(req *http.Request) string {
+	if id, ok := req.Context().Value(requestIDKey{}).(string); ok {
+		return id
+	}
+	return "-"
+}
+
+// newRequestID returns a random RFC 4122 version 4 UUID, such as
+// 0f8b5c2e-3d4a-4f6b-9c1d-2e7a8b9c0d1e.
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "-"
+	}
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	var id [36]byte
+	hex.Encode(id[0:8], b[0:4])
+	hex.Encode(id[9:13], b[4:6])
+	hex.Encode(id[14:18], b[6:8])
+	hex.Encode(id[19:23], b[8:10])
+	hex.Encode(id[24:], b[10:])
+	id[8], id[13], id[18], id[23] = '-', '-', '-', '-'
+	return string(id[:])
+}
+
+// validRequestID returns whether id is non-empty, no longer than maxRequestIDLen, and made up of
+// only printable ASCII characters other than spaces, so that it can't corrupt log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if c := id[i]; c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	generatedRE := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	tests := []struct {
+		header   string // X-Request-ID sent, if any
+		generate bool
+		want     string // X-Request-ID echoed, or "generated" for a new ID
+	}{
+		{header: "abc-123", want: "abc-123"},
+		{header: "abc-123", generate: true, want: "abc-123"},
+		{want: ""},
+		{generate: true, want: "generated"},
+		{header: "bad id", want: ""},
+		{header: strings.Repeat("x", maxRequestIDLen+1), want: ""},
+		{header: "bad\tid", generate: true, want: "generated"},
+	}
+	for _, tt := range tests {
+		buf, restore := captureLog()
+		h := withRequestID(accessLog(newTestRouter(t, "rsc.io/*", "https://github.com/rsc/*"), "json"), tt.generate)
+		req := httptest.NewRequest(http.MethodGet, "http://rsc.io/pdf?go-get=1", nil)
+		if tt.header != "" {
+			req.Header.Set("X-Request-ID", tt.header)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		restore()
+
+		id := w.Header().Get("X-Request-ID")
+		switch {
+		case tt.want == "generated" && !generatedRE.MatchString(id):
+			t.Errorf("X-Request-ID %q with generate=%t: echoed %q, want a version 4 UUID", tt.header, tt.generate, id)
+		case tt.want != "generated" && id != tt.want:
+			t.Errorf("X-Request-ID %q with generate=%t: echoed %q, want %q", tt.header, tt.generate, id, tt.want)
+		}
+
+		var e accessEntry
+		if err := json.Unmarshal(buf.Bytes(), &e); err != nil {
+			t.Fatalf("error decoding access log entry %q: %v", buf.String(), err)
+		}
+		logged := id
+		if logged == "" {
+			logged = "-"
+		}
+		if e.RequestID != logged {
+			t.Errorf("X-Request-ID %q with generate=%t: logged request_id %q, want %q", tt.header, tt.generate, e.RequestID, logged)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package main
+
+import "syscall"
+
+// reusePortControl is nil on platforms without SO_REUSEPORT.
+var reusePortControl func(network, address string, c syscall.RawConn) error
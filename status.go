@@ -0,0 +1,78 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// statusPath is the path the status page is served at under -debug.
+const statusPath = "/debug/status"
+
+// serverStatus is the content of the status page.
+type serverStatus struct {
+	Version      string   `json:"version"`
+	Listen       []string `json:"listen"`
+	TLS          string   `json:"tls"`
+	Redirects    int      `json:"redirects"`
+	GracePeriod  string   `json:"grace_period"`
+	ReadTimeout  string   `json:"read_timeout"`
+	WriteTimeout string   `json:"write_timeout"`
+	IdleTimeout  string   `json:"idle_timeout"`
+	Uptime       string   `json:"uptime"`
+}
+
+// statusPage returns a handler that serves the status of the server at statusPath on any host, as
+// JSON if requested by the Accept header or as plain text otherwise, and passes every other
+// request to next. tls describes how TLS is configured.
+func statusPage(next http.Handler, routes *routerSwitch, listeners []net.Listener, tls string) http.Handler {
+	started := time.Now()
+	addrs := make([]string, len(listeners))
+	for i, l := range listeners {
+		addrs[i] = l.Addr().String()
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != statusPath {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		st := serverStatus{
+			Version:      versionString(),
+			Listen:       addrs,
+			TLS:          tls,
+			Redirects:    routes.load().Len(),
+			GracePeriod:  gracePeriod.String(),
+			ReadTimeout:  readTimeout.String(),
+			WriteTimeout: writeTimeout.String(),
+			IdleTimeout:  idleTimeout.String(),
+			Uptime:       time.Since(started).Truncate(time.Second).String(),
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		if strings.Contains(req.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(&st)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+		fmt.Fprintf(tw, "version:\t%s\n", st.Version)
+		fmt.Fprintf(tw, "listen:\t%s\n", strings.Join(st.Listen, ", "))
+		fmt.Fprintf(tw, "tls:\t%s\n", st.TLS)
+		fmt.Fprintf(tw, "redirects:\t%d\n", st.Redirects)
+		fmt.Fprintf(tw, "grace period:\t%s\n", st.GracePeriod)
+		fmt.Fprintf(tw, "read timeout:\t%s\n", st.ReadTimeout)
+		fmt.Fprintf(tw, "write timeout:\t%s\n", st.WriteTimeout)
+		fmt.Fprintf(tw, "idle timeout:\t%s\n", st.IdleTimeout)
+		fmt.Fprintf(tw, "uptime:\t%s\n", st.Uptime)
+		tw.Flush()
+	})
+}
@@ -0,0 +1,89 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestStatusPage(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	sock := filepath.Join(dir, "redirector.sock")
+	s := startMain(t, sock, nil, "-listen=unix:"+sock, "-debug",
+		"rsc.io/*", "https://github.com/rsc/*",
+		"9fans.net/go", "https://github.com/9fans/go")
+	defer s.stop(t, syscall.SIGTERM)
+	client := unixClient(sock)
+
+	// The status page is served on every host, taking precedence over import paths covering it.
+	for _, url := range []string{"http://rsc.io/debug/status", "http://example.com/debug/status"} {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", "application/json")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", url, err)
+		}
+		var st serverStatus
+		err = json.NewDecoder(resp.Body).Decode(&st)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("GET %s: error decoding status: %v", url, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s: status = %d, want %d", url, resp.StatusCode, http.StatusOK)
+		}
+		if st.Redirects != 2 {
+			t.Errorf("GET %s: redirects = %d, want 2", url, st.Redirects)
+		}
+		if st.TLS != "off" {
+			t.Errorf("GET %s: tls = %q, want %q", url, st.TLS, "off")
+		}
+		if len(st.Listen) != 1 {
+			t.Errorf("GET %s: listen = %q, want one address", url, st.Listen)
+		}
+	}
+
+	url := "http://rsc.io/debug/status"
+	resp, body := fetch(t, client, url)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET %s: status = %d, want %d", url, resp.StatusCode, http.StatusOK)
+	}
+	if !regexp.MustCompile(`(?m)^redirects:\s+2$`).MatchString(body) {
+		t.Errorf("GET %s: body = %q, want a redirect count of 2", url, body)
+	}
+}
+
+func TestStatusPageDisabled(t *testing.T) {
+	url := "http://example.com/debug/status"
+	resp, _ := fetchMain(t, url, "rsc.io/pdf", "https://github.com/rsc/pdf")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET %s without -debug: status = %d, want %d", url, resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestStatusPageCollision(t *testing.T) {
+	for _, pair := range [][2]string{
+		{"rsc.io/debug/*", "https://github.com/rsc/*"},
+		{"rsc.io/debug", "https://github.com/rsc/debug"},
+	} {
+		out, err := runMain(t, nil, "-check", "-debug", pair[0], pair[1])
+		if err != nil {
+			t.Errorf("running with -debug and %s = %v, %q; want the status page to take precedence", pair[0], err, out)
+		}
+	}
+	out, err := runMain(t, nil, "-check", "-debug", "rsc.io/debug/status", "https://github.com/rsc/status")
+	if err == nil || !strings.Contains(out, "-debug status page /debug/status collides with import path rsc.io/debug/status") {
+		t.Errorf("running with -debug and rsc.io/debug/status = %v, %q; want an error", err, out)
+	}
+}
@@ -0,0 +1,72 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestSystemdListenerNotActivated(t *testing.T) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	pid := strconv.Itoa(os.Getpid())
+	tests := []struct {
+		pid, fds string
+		err      bool
+	}{
+		{"", "", false},
+		{pid, "", false},
+		// Variables meant for another process, such as a parent, are ignored.
+		{strconv.Itoa(os.Getppid()), "1", false},
+		{pid, "0", true},
+		{pid, "x", true},
+	}
+	for _, tt := range tests {
+		os.Setenv("LISTEN_PID", tt.pid)
+		os.Setenv("LISTEN_FDS", tt.fds)
+		l, err := systemdListener()
+		if l != nil || (err != nil) != tt.err {
+			t.Errorf("systemdListener() with LISTEN_PID=%q LISTEN_FDS=%q = %v, %v; want nil and error %t",
+				tt.pid, tt.fds, l, err, tt.err)
+		}
+		if tt.pid != "" && tt.fds != "" && (os.Getenv("LISTEN_PID") != "" || os.Getenv("LISTEN_FDS") != "") {
+			t.Errorf("systemdListener() with LISTEN_PID=%q LISTEN_FDS=%q left them set", tt.pid, tt.fds)
+		}
+	}
+}
+
+func TestSystemdListener(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	sock := filepath.Join(dir, "systemd.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	f, err := l.(*net.UnixListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// The socket is passed as fd 3, and -listen is ignored.
+	cmd := command([]string{"LISTEN_PID=self", "LISTEN_FDS=1"},
+		"-listen=unix:"+filepath.Join(dir, "unused.sock"), "rsc.io/*", "https://github.com/rsc/*")
+	cmd.ExtraFiles = []*os.File{f}
+	s := startCommand(t, unixClient(sock), cmd)
+	defer s.stop(t, syscall.SIGTERM)
+	_, body := fetch(t, unixClient(sock), "http://rsc.io/pdf?go-get=1")
+	if meta, want := goImport(body), "rsc.io/pdf git https://github.com/rsc/pdf"; meta != want {
+		t.Errorf("GET rsc.io/pdf on the systemd socket: go-import = %q, want %q", meta, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "unused.sock")); !os.IsNotExist(err) {
+		t.Errorf("-listen socket was created alongside the systemd socket: %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"go.spiff.io/go-import-redirector/redirector"
+)
+
+const (
+	// verifyInterval is the minimum time between requests made by verifyRepos.
+	verifyInterval = 250 * time.Millisecond
+	// verifyRequestTimeout is the longest verifyRepos waits for any one repository.
+	verifyRequestTimeout = 10 * time.Second
+	// verifyTimeout is the longest buildRouter lets verifyRepos run, so that a slow remote can't
+	// hold up a reload or the signals handled after it.
+	verifyTimeout = 30 * time.Second
+)
+
+// verifyRepos checks that the repository of each redirect exists by making a HEAD request to its
+// URL, and returns the number of repositories that were not found. Wildcard redirects, redirects to
+// non-HTTP(S) URLs, and redirects whose import path is in skip are not checked.
+//
+// Only 404 and 410 responses count as missing repositories. Requests that fail outright are logged
+// and otherwise ignored, since they say nothing about whether the repository exists. If ctx is done
+// before every repository is checked, the rest are logged as unchecked and not counted.
+func verifyRepos(ctx context.Context, redirects []*redirector.Redirect, skip []string) (missing int) {
+	skipped := make(map[string]bool, len(skip))
+	for _, importPath := range skip {
+		skipped[importPath] = true
+	}
+
+	client := &http.Client{Timeout: verifyRequestTimeout}
+	tick := time.NewTicker(verifyInterval)
+	defer tick.Stop()
+	for i, r := range redirects {
+		repo := r.Repo()
+		if r.Wildcard() || r.Subdomains() || skipped[r.ImportPath()] || (repo.Scheme != "https" && repo.Scheme != "http") {
+			continue
+		}
+		if i > 0 {
+			select {
+			case <-tick.C:
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			log.Printf("stopped verifying repos: %d redirect(s) not checked: %v", len(redirects)-i, ctx.Err())
+			return missing
+		}
+
+		repoURL := repo.String()
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, repoURL, nil)
+		if err != nil {
+			log.Printf("unable to verify repo %s for %s: %v", repoURL, r.ImportPath(), err)
+			continue
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			log.Printf("unable to verify repo %s for %s: %v", repoURL, r.ImportPath(), err)
+			continue
+		}
+		resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusNotFound, http.StatusGone:
+			log.Printf("repo %s for %s not found: %s", repoURL, r.ImportPath(), resp.Status)
+			missing++
+		default:
+			debugf("verified repo %s for %s: %s", repoURL, r.ImportPath(), resp.Status)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,104 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestVerifyRepos(t *testing.T) {
+	var mu sync.Mutex
+	var requested []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		requested = append(requested, req.Method+" "+req.URL.Path)
+		mu.Unlock()
+		switch req.URL.Path {
+		case "/ok":
+		case "/gone":
+			w.WriteHeader(http.StatusGone)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	down := "http://127.0.0.1:" + freePort(t) + "/down"
+	redirects := newRedirects(t,
+		"example.com/ok", srv.URL+"/ok",
+		"example.com/missing", srv.URL+"/missing",
+		"example.com/gone", srv.URL+"/gone",
+		"example.com/down", down,
+		"example.com/skipped", srv.URL+"/skipped",
+		"example.com/*", srv.URL+"/*",
+		"example.com/ssh", "ssh://git@example.net/ssh")
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(logWriter)
+	missing := verifyRepos(context.Background(), redirects, []string{"example.com/skipped"})
+	if missing != 2 {
+		t.Errorf("verifyRepos found %d missing repo(s), want 2\n%s", missing, buf.String())
+	}
+
+	out := buf.String()
+	for _, want := range []string{
+		"repo " + srv.URL + "/missing for example.com/missing not found: 404 Not Found",
+		"repo " + srv.URL + "/gone for example.com/gone not found: 410 Gone",
+		"unable to verify repo " + down + " for example.com/down: ",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("verifyRepos log %q does not contain %q", out, want)
+		}
+	}
+	if strings.Contains(out, "/ok") {
+		t.Errorf("verifyRepos logged the repo that exists: %q", out)
+	}
+	want := []string{"HEAD /ok", "HEAD /missing", "HEAD /gone"}
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(requested, ", ") != strings.Join(want, ", ") {
+		t.Errorf("verifyRepos requested %q, want %q", requested, want)
+	}
+}
+
+func TestVerifyReposTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-req.Context().Done()
+	}))
+	defer srv.Close()
+	redirects := newRedirects(t,
+		"example.com/slow", srv.URL+"/slow",
+		"example.com/missing", srv.URL+"/missing",
+		"example.com/other", srv.URL+"/other")
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(logWriter)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if missing := verifyRepos(ctx, redirects, nil); missing != 0 {
+		t.Errorf("verifyRepos found %d missing repo(s), want 0", missing)
+	}
+	if d := time.Since(start); d > verifyInterval+time.Second {
+		t.Errorf("verifyRepos took %v after its context was done", d)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"unable to verify repo " + srv.URL + "/slow for example.com/slow: ",
+		"stopped verifying repos: 2 redirect(s) not checked: context deadline exceeded",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("verifyRepos log %q does not contain %q", out, want)
+		}
+	}
+}
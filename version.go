@@ -0,0 +1,40 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// version, commit, and buildDate describe the build, and may be set when building with -ldflags:
+//
+//	go build -ldflags "-X main.version=v1.0.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)"
+//
+// If version is not set, the module version from the binary's build info is used.
+var (
+	version   string
+	commit    string
+	buildDate string
+)
+
+// versionString returns a line describing the build for -version.
+func versionString() string {
+	v := version
+	if v == "" {
+		if info, ok := debug.ReadBuildInfo(); ok {
+			v = info.Main.Version
+		}
+	}
+	return fmt.Sprintf("go-import-redirector %s (commit %s, built %s, %s)",
+		orUnknown(v), orUnknown(commit), orUnknown(buildDate), runtime.Version())
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
@@ -0,0 +1,37 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestVersionString(t *testing.T) {
+	defer func(v, c, d string) { version, commit, buildDate = v, c, d }(version, commit, buildDate)
+
+	version, commit, buildDate = "", "", ""
+	s := versionString()
+	if !strings.HasPrefix(s, "go-import-redirector ") || !strings.Contains(s, runtime.Version()) {
+		t.Errorf("versionString() = %q, want the program name and Go version", s)
+	}
+
+	version, commit, buildDate = "v1.2.3", "abc123", "2020-01-02T03:04:05Z"
+	want := "go-import-redirector v1.2.3 (commit abc123, built 2020-01-02T03:04:05Z, " + runtime.Version() + ")"
+	if s := versionString(); s != want {
+		t.Errorf("versionString() = %q, want %q", s, want)
+	}
+}
+
+func TestVersionFlag(t *testing.T) {
+	// No import and repo pairs are needed with -version.
+	out, err := runMain(t, nil, "-version")
+	if err != nil {
+		t.Fatalf("running with -version failed: %v\n%s", err, out)
+	}
+	if !strings.HasPrefix(out, "go-import-redirector ") {
+		t.Errorf("-version printed %q, want a version line", out)
+	}
+}